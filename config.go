@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// Config structure
+type Config struct {
+	Lights            map[string]*LightRecord `json:"lights"`           // keyed by serial number
+	Order             []string                `json:"order,omitempty"`  // light keys, in index order
+	Groups            map[string][]string     `json:"groups,omitempty"` // group name to light keys
+	Scenes            map[string]Scene        `json:"scenes,omitempty"`
+	LastBrightness    int                     `json:"lastBrightness"`
+	LastTemperature   int                     `json:"lastTemperature"`
+	LastSelectedLight string                  `json:"lastSelectedLight"`
+	LiveApply         bool                    `json:"liveApply,omitempty"` // TUI sliders apply without Enter
+
+	// Discovery defaults, overridden by detect --timeout and --interface
+	DiscoveryTimeout    string   `json:"discoveryTimeout,omitempty"` // e.g. "5s"
+	DiscoveryInterfaces []string `json:"discoveryInterfaces,omitempty"`
+
+	// relocate, if set, records a light found at a new address instead of
+	// relocateLight
+	relocate func(light *LightRecord, ip string, port int)
+}
+
+// Scene is a saved state for a set of lights, keyed by light key
+type Scene map[string]keylight.State
+
+// LightRecord is a configured light. Lights are keyed by serial number so
+// that a new DHCP lease or a rename in Control Center updates the record
+// instead of breaking it.
+type LightRecord struct {
+	Serial   string `json:"serial"`
+	Name     string `json:"name"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Instance string `json:"instance,omitempty"` // mDNS instance name
+	Stale    bool   `json:"stale,omitempty"`    // did not answer the last detect
+
+	CustomName bool `json:"customName,omitempty"` // name set by the user; discovery keeps it
+}
+
+// Addr returns the address to reach the light at, including the port when
+// it is not the default. IPv6 addresses are bracketed when a port is added.
+func (l *LightRecord) Addr() string {
+	if l.Port == 0 || l.Port == keylight.DefaultPort {
+		return l.IP
+	}
+	return net.JoinHostPort(l.IP, strconv.Itoa(l.Port))
+}
+
+// discoveryOptions returns the configured discovery settings, using
+// fallback when no timeout is configured
+func (c *Config) discoveryOptions(fallback time.Duration) discoveryOptions {
+	opts := discoveryOptions{Timeout: fallback, Interfaces: c.DiscoveryInterfaces}
+	if d, err := time.ParseDuration(c.DiscoveryTimeout); err == nil && d > 0 {
+		opts.Timeout = d
+	}
+	return opts
+}
+
+// orderedLights returns the lights in index order
+func (c *Config) orderedLights() []*LightRecord {
+	lights := make([]*LightRecord, 0, len(c.Order))
+	for _, key := range c.Order {
+		lights = append(lights, c.Lights[key])
+	}
+	return lights
+}
+
+// normalizeOrder makes Order list every light exactly once. Lights missing
+// from it (older configs, hand edits) are appended sorted by name, so their
+// indexes are at least stable from run to run.
+func (c *Config) normalizeOrder() {
+	seen := make(map[string]bool, len(c.Lights))
+	order := make([]string, 0, len(c.Lights))
+	for _, key := range c.Order {
+		if _, ok := c.Lights[key]; ok && !seen[key] {
+			seen[key] = true
+			order = append(order, key)
+		}
+	}
+
+	var missing []string
+	for key := range c.Lights {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return c.Lights[missing[i]].Name < c.Lights[missing[j]].Name
+	})
+	c.Order = append(order, missing...)
+}
+
+// moveLight moves a light to a 1-based position, shifting the others
+func (c *Config) moveLight(light *LightRecord, position int) {
+	key := c.keyOf(light)
+	order := make([]string, 0, len(c.Order))
+	for _, k := range c.Order {
+		if k != key {
+			order = append(order, k)
+		}
+	}
+	position = max(1, min(position, len(order)+1))
+	order = append(order[:position-1], append([]string{key}, order[position-1:]...)...)
+	c.Order = order
+}
+
+// keyOf returns the key a light is stored under
+func (c *Config) keyOf(light *LightRecord) string {
+	for key, l := range c.Lights {
+		if l == light {
+			return key
+		}
+	}
+	return ""
+}
+
+// renameKey updates references to a light whose key changed, e.g. when its
+// serial number becomes known
+func (c *Config) renameKey(oldKey, newKey string) {
+	for i, key := range c.Order {
+		if key == oldKey {
+			c.Order[i] = newKey
+		}
+	}
+	for _, keys := range c.Groups {
+		for i, key := range keys {
+			if key == oldKey {
+				keys[i] = newKey
+			}
+		}
+	}
+	for _, scene := range c.Scenes {
+		if state, ok := scene[oldKey]; ok {
+			delete(scene, oldKey)
+			scene[newKey] = state
+		}
+	}
+}
+
+// groupLights returns the lights in a group, in the group's order
+func (c *Config) groupLights(name string) ([]*LightRecord, bool) {
+	keys, ok := c.Groups[name]
+	if !ok {
+		return nil, false
+	}
+	lights := make([]*LightRecord, 0, len(keys))
+	for _, key := range keys {
+		if light, ok := c.Lights[key]; ok {
+			lights = append(lights, light)
+		}
+	}
+	return lights, true
+}
+
+// groupNames returns the group names, sorted
+func (c *Config) groupNames() []string {
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setGroup stores a group's lights, dropping duplicates
+func (c *Config) setGroup(name string, lights []*LightRecord) {
+	if c.Groups == nil {
+		c.Groups = make(map[string][]string)
+	}
+	keys := make([]string, 0, len(lights))
+	seen := make(map[string]bool, len(lights))
+	for _, light := range lights {
+		key := c.keyOf(light)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	c.Groups[name] = keys
+}
+
+// sceneNames returns the scene names, sorted
+func (c *Config) sceneNames() []string {
+	names := make([]string, 0, len(c.Scenes))
+	for name := range c.Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errAmbiguousName is returned by findLight for a name that more than one
+// light has
+var errAmbiguousName = errors.New("ambiguous light name")
+
+// findLight looks a light up by serial number, name or index (as shown by
+// list). Serials and names win, so a light called "2nd Light" or "42" is
+// found by its name. A name that several lights share finds none of them.
+func (c *Config) findLight(id string) (*LightRecord, error) {
+	if light, ok := c.Lights[id]; ok {
+		return light, nil
+	}
+
+	var named []*LightRecord
+	for _, key := range c.Order {
+		if light := c.Lights[key]; light != nil && light.Name == id {
+			named = append(named, light)
+		}
+	}
+	switch {
+	case len(named) == 1:
+		return named[0], nil
+	case len(named) > 1:
+		return nil, fmt.Errorf("%w: %d lights are called '%s'; use the serial number or index from 'keylight list'", errAmbiguousName, len(named), id)
+	}
+
+	if index, err := strconv.Atoi(id); err == nil && index > 0 && index <= len(c.Order) {
+		return c.Lights[c.Order[index-1]], nil
+	}
+	return nil, fmt.Errorf("light '%s' not found; use 'keylight list' to see available lights", id)
+}
+
+// forgetLight removes a light from the config
+func (c *Config) forgetLight(light *LightRecord) {
+	key := c.keyOf(light)
+	delete(c.Lights, key)
+	c.normalizeOrder()
+
+	for name, keys := range c.Groups {
+		kept := keys[:0]
+		for _, k := range keys {
+			if k != key {
+				kept = append(kept, k)
+			}
+		}
+		c.Groups[name] = kept
+	}
+	for _, scene := range c.Scenes {
+		delete(scene, key)
+	}
+}
+
+// Config management
+func getConfigPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "keylight", "config.json")
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Lights:          make(map[string]*LightRecord),
+		LastBrightness:  50,
+		LastTemperature: 4000,
+	}
+}
+
+func loadConfig() *Config {
+	configPath := getConfigPath()
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return defaultConfig()
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		if legacy := loadLegacyConfig(data); legacy != nil {
+			return legacy
+		}
+		return defaultConfig()
+	}
+	if config.Lights == nil {
+		config.Lights = make(map[string]*LightRecord)
+	}
+	config.normalizeOrder()
+
+	return &config
+}
+
+// loadLegacyConfig reads configs written before lights were keyed by serial
+// number, when "lights" mapped the mDNS instance name to an IPv4 address.
+// The serial is filled in the next time the light is discovered; until then
+// the record is keyed by its name.
+func loadLegacyConfig(data []byte) *Config {
+	var legacy struct {
+		Lights            map[string]string `json:"lights"`
+		LastBrightness    int               `json:"lastBrightness"`
+		LastTemperature   int               `json:"lastTemperature"`
+		LastSelectedLight string            `json:"lastSelectedLight"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil
+	}
+
+	config := &Config{
+		Lights:            make(map[string]*LightRecord, len(legacy.Lights)),
+		LastBrightness:    legacy.LastBrightness,
+		LastTemperature:   legacy.LastTemperature,
+		LastSelectedLight: legacy.LastSelectedLight,
+	}
+	for name, ip := range legacy.Lights {
+		config.Lights[name] = &LightRecord{Name: name, IP: ip, Instance: name}
+	}
+	config.normalizeOrder()
+	return config
+}
+
+func saveConfig(config *Config) {
+	configPath := getConfigPath()
+	os.MkdirAll(filepath.Dir(configPath), 0755)
+
+	data, _ := json.MarshalIndent(config, "", "  ")
+	os.WriteFile(configPath, data, 0644)
+}
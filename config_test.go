@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFindLight(t *testing.T) {
+	config := &Config{Lights: map[string]*LightRecord{
+		"BW001": {Serial: "BW001", Name: "Desk"},
+		"BW002": {Serial: "BW002", Name: "2nd Light"},
+		"BW003": {Serial: "BW003", Name: "1"},
+	}, Order: []string{"BW001", "BW002", "BW003"}}
+
+	tests := []struct {
+		id   string
+		want string // serial, or "" for none
+	}{
+		{"BW002", "BW002"},
+		{"Desk", "BW001"},
+		{"2nd Light", "BW002"}, // not index 2
+		{"1", "BW003"},         // a name wins over an index
+		{"2", "BW002"},
+		{"3", "BW003"},
+		{"4", ""},
+		{"0", ""},
+		{"-1", ""},
+		{"2x", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := config.findLight(tt.id)
+		if (err != nil) != (tt.want == "") || (got == nil && tt.want != "") || (got != nil && got.Serial != tt.want) {
+			t.Errorf("findLight(%q) = %+v, %v; want %q", tt.id, got, err, tt.want)
+		}
+	}
+}
+
+// Lights keep the factory name until they are renamed, so two can share it
+func TestFindLightSameName(t *testing.T) {
+	config := &Config{Lights: map[string]*LightRecord{
+		"BW001": {Serial: "BW001", Name: "Elgato Key Light"},
+		"BW002": {Serial: "BW002", Name: "Elgato Key Light"},
+		"BW003": {Serial: "BW003", Name: "Desk"},
+	}, Order: []string{"BW002", "BW003", "BW001"}}
+
+	for i := 0; i < 10; i++ {
+		if got, err := config.findLight("Elgato Key Light"); got != nil || !errors.Is(err, errAmbiguousName) {
+			t.Fatalf("findLight() = %+v, %v; want an ambiguous name error", got, err)
+		}
+	}
+	// The serial or the index still tells them apart
+	for id, want := range map[string]string{"BW001": "BW001", "1": "BW002", "3": "BW001"} {
+		if got, err := config.findLight(id); err != nil || got.Serial != want {
+			t.Errorf("findLight(%q) = %+v, %v; want %s", id, got, err, want)
+		}
+	}
+}
+
+func TestSetGroup(t *testing.T) {
+	desk := &LightRecord{Serial: "BW001", Name: "Desk"}
+	shelf := &LightRecord{Serial: "BW002", Name: "Shelf"}
+	config := &Config{Lights: map[string]*LightRecord{"BW001": desk, "BW002": shelf}}
+
+	// Lights keep the order given, once each
+	config.setGroup("desk", []*LightRecord{shelf, desk, shelf})
+	if want := []string{"BW002", "BW001"}; !reflect.DeepEqual(config.Groups["desk"], want) {
+		t.Errorf("group desk = %v, want %v", config.Groups["desk"], want)
+	}
+	lights, ok := config.groupLights("desk")
+	if !ok || len(lights) != 2 || lights[0] != shelf || lights[1] != desk {
+		t.Errorf("groupLights() = %+v, %v", lights, ok)
+	}
+
+	config.setGroup("empty", nil)
+	if lights, ok := config.groupLights("empty"); !ok || len(lights) != 0 {
+		t.Errorf("groupLights(empty) = %+v, %v", lights, ok)
+	}
+	if _, ok := config.groupLights("missing"); ok {
+		t.Error("groupLights() found a group that does not exist")
+	}
+	if want := []string{"desk", "empty"}; !reflect.DeepEqual(config.groupNames(), want) {
+		t.Errorf("groupNames() = %v, want %v", config.groupNames(), want)
+	}
+}
+
+func TestForgetLight(t *testing.T) {
+	desk := &LightRecord{Serial: "BW001", Name: "Desk"}
+	shelf := &LightRecord{Serial: "BW002", Name: "Shelf"}
+	config := &Config{
+		Lights: map[string]*LightRecord{"BW001": desk, "BW002": shelf},
+		Order:  []string{"BW002", "BW001"},
+		Groups: map[string][]string{"all": {"BW001", "BW002"}, "desk": {"BW001"}},
+		Scenes: map[string]Scene{"evening": {"BW001": {On: true, Brightness: 30}, "BW002": {}}},
+	}
+
+	config.forgetLight(desk)
+	if _, ok := config.Lights["BW001"]; ok {
+		t.Error("light still configured")
+	}
+	if want := []string{"BW002"}; !reflect.DeepEqual(config.Order, want) {
+		t.Errorf("Order = %v, want %v", config.Order, want)
+	}
+	// Groups lose the light but are kept, even when empty
+	if want := map[string][]string{"all": {"BW002"}, "desk": {}}; !reflect.DeepEqual(config.Groups, want) {
+		t.Errorf("Groups = %v, want %v", config.Groups, want)
+	}
+	if want := (Scene{"BW002": {}}); !reflect.DeepEqual(config.Scenes["evening"], want) {
+		t.Errorf("scene evening = %v, want %v", config.Scenes["evening"], want)
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	light := &LightRecord{Serial: "BW001", Name: "Desk"}
+	config := &Config{
+		Lights: map[string]*LightRecord{"BW001": light, "BW002": {Serial: "BW002", Name: "Shelf"}},
+		Order:  []string{"BW002", "Desk"},
+		Groups: map[string][]string{"all": {"Desk", "BW002"}, "shelf": {"BW002"}},
+		Scenes: map[string]Scene{"evening": {"Desk": {On: true, Brightness: 30}, "BW002": {}}},
+	}
+
+	// The record was stored under its name until its serial was known
+	config.renameKey("Desk", "BW001")
+	if want := []string{"BW002", "BW001"}; !reflect.DeepEqual(config.Order, want) {
+		t.Errorf("Order = %v, want %v", config.Order, want)
+	}
+	if want := map[string][]string{"all": {"BW001", "BW002"}, "shelf": {"BW002"}}; !reflect.DeepEqual(config.Groups, want) {
+		t.Errorf("Groups = %v, want %v", config.Groups, want)
+	}
+	if lights, _ := config.groupLights("all"); len(lights) != 2 || lights[0] != light {
+		t.Errorf("group all = %+v", lights)
+	}
+	if want := (Scene{"BW001": {On: true, Brightness: 30}, "BW002": {}}); !reflect.DeepEqual(config.Scenes["evening"], want) {
+		t.Errorf("scene evening = %v, want %v", config.Scenes["evening"], want)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// daemon is the running keylight daemon, or nil to talk to the lights
+// directly. handleCLI sets it when the daemon's socket answers.
+var daemon *daemonClient
+
+// errNoDaemon means the daemon could not take a request, which should then
+// go to the light directly
+var errNoDaemon = errors.New("daemon unavailable")
+
+// getSocketPath is where the daemon listens, next to the config
+func getSocketPath() string {
+	return filepath.Join(filepath.Dir(getConfigPath()), "daemon.sock")
+}
+
+// daemonClient sends CLI requests to the daemon's API over its socket
+type daemonClient struct {
+	http *http.Client
+}
+
+// dialDaemon returns a client for the daemon, or nil if none is running
+func dialDaemon() *daemonClient {
+	path := getSocketPath()
+	conn, err := net.DialTimeout("unix", path, 100*time.Millisecond)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+	return &daemonClient{http: &http.Client{Transport: &http.Transport{DialContext: dial}}}
+}
+
+// light returns a handle that sends requests for a configured light through
+// the daemon, falling back to direct when the daemon cannot take them
+func (d *daemonClient) light(light *LightRecord, direct keylight.Light) keylight.Light {
+	path := "/lights/" + neturl.PathEscape(recordKey(light))
+	return &daemonLight{daemon: d, path: path, direct: direct}
+}
+
+// daemonLight is a keylight.Light backed by the daemon. Reads come from the
+// daemon's cached state and changes go out over its open connections to
+// the lights.
+type daemonLight struct {
+	daemon *daemonClient
+	path   string
+	direct keylight.Light
+}
+
+func (l *daemonLight) State(ctx context.Context) (keylight.State, error) {
+	v, err := l.daemon.do(ctx, http.MethodGet, l.path, nil)
+	if errors.Is(err, errNoDaemon) {
+		return l.direct.State(ctx)
+	}
+	if err == nil && !v.OK {
+		err = errors.New(v.Error)
+	}
+	if err != nil {
+		return keylight.State{}, err
+	}
+	if v.State == nil {
+		return keylight.State{}, fmt.Errorf("no state read from %s yet", v.Name)
+	}
+	return *v.State, nil
+}
+
+func (l *daemonLight) Set(ctx context.Context, p keylight.Patch) error {
+	if p.On == nil && p.Brightness == nil && p.Temperature == nil {
+		return nil
+	}
+	// The change was made once the daemon answers; reading the state back
+	// afterwards may still fail, which is no concern of Set
+	_, err := l.daemon.do(ctx, http.MethodPatch, l.path, apiPatch{On: p.On, Brightness: p.Brightness, Temperature: p.Temperature})
+	if errors.Is(err, errNoDaemon) {
+		return l.direct.Set(ctx, p)
+	}
+	return err
+}
+
+func (l *daemonLight) Toggle(ctx context.Context) (bool, error) {
+	v, err := l.daemon.do(ctx, http.MethodPost, l.path+"/toggle", nil)
+	if errors.Is(err, errNoDaemon) {
+		return l.direct.Toggle(ctx)
+	}
+	if err == nil && !v.OK {
+		err = errors.New(v.Error)
+	}
+	if err != nil {
+		return false, err
+	}
+	return v.State != nil && v.State.On, nil
+}
+
+// Info is not cached by the daemon
+func (l *daemonLight) Info(ctx context.Context) (keylight.AccessoryInfo, error) {
+	return l.direct.Info(ctx)
+}
+
+// do sends a request to the daemon and decodes the light it answers with,
+// whose OK and Error tell how the light's last request went. It returns
+// errNoDaemon if the daemon is gone or does not know the light (it was
+// added after the daemon last read the config).
+func (d *daemonClient) do(ctx context.Context, method, path string, body any) (apiLight, error) {
+	var rd io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://keylight"+path, rd)
+	if err != nil {
+		return apiLight{}, err
+	}
+	// Changes must be sent as JSON, even those without a body
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return apiLight{}, errNoDaemon
+		}
+		return apiLight{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return apiLight{}, errNoDaemon
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return apiLight{}, errors.New(e.Error)
+	}
+	var v apiLight
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return apiLight{}, err
+	}
+	return v, nil
+}
+
+func cliDaemon(config *Config, args []string) {
+	const usage = "Usage: keylight daemon [--poll 5s]"
+
+	opts, rest, err := cutOptions(args, []string{"--poll"}, nil)
+	if err != nil {
+		out.fail(exitUsage, "%v\n%s", err, usage)
+	}
+	if len(rest) > 0 {
+		out.fail(exitUsage, "Unknown option: %s\n%s", rest[0], usage)
+	}
+	interval := defaultPollInterval
+	for _, opt := range opts {
+		d, err := time.ParseDuration(opt.value)
+		if err != nil || d <= 0 {
+			out.fail(exitUsage, "Invalid poll interval: %s", opt.value)
+		}
+		interval = d
+	}
+
+	// A socket left behind by a daemon that did not shut down is removed
+	path := getSocketPath()
+	if dialDaemon() != nil {
+		out.fail(exitFailed, "✗ The daemon is already running (%s)", path)
+	}
+	os.Remove(path)
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		out.fail(exitFailed, "✗ %v", err)
+	}
+	os.Chmod(path, 0600)
+
+	out.say("Daemon serving %d light(s) on %s", len(config.Lights), path)
+	if err := newServer(config).run(l, interval, 0); err != nil {
+		out.fail(exitFailed, "✗ %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// testDaemon serves a server's API and returns a client for it, as the CLI
+// would dial the daemon's socket
+func testDaemon(t *testing.T, s *server) *daemonClient {
+	t.Helper()
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+	}
+	return &daemonClient{http: &http.Client{Transport: &http.Transport{DialContext: dial}}}
+}
+
+// The CLI's requests through the daemon pass the server's checks
+func TestDaemonSendsJSON(t *testing.T) {
+	f, record := newFakeLight(t, "BW001", "Desk")
+	d := testDaemon(t, newServer(testConfig(t, record)))
+	light := d.light(record, nil)
+	on, err := light.Toggle(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if on || f.current().On {
+		t.Errorf("Toggle() = %v, light on = %v; want both off", on, f.current().On)
+	}
+}
+
+// Lights are asked for by key, so lights that share a name, and records
+// from before serials, reach the right light
+func TestDaemonLightKey(t *testing.T) {
+	left, leftRecord := newFakeLight(t, "BW001", "Elgato Key Light")
+	right, rightRecord := newFakeLight(t, "BW002", "Elgato Key Light")
+	legacy, legacyRecord := newFakeLight(t, "", "Shelf")
+	d := testDaemon(t, newServer(testConfig(t, leftRecord, rightRecord, legacyRecord)))
+
+	for _, record := range []*LightRecord{rightRecord, legacyRecord} {
+		if err := d.light(record, nil).Set(context.Background(), keylight.Patch{Brightness: keylight.Int(20)}); err != nil {
+			t.Fatalf("Set(%s): %v", recordKey(record), err)
+		}
+	}
+	if left.current().Brightness != 50 || right.current().Brightness != 20 || legacy.current().Brightness != 20 {
+		t.Errorf("brightness = %d, %d, %d; want 50, 20, 20",
+			left.current().Brightness, right.current().Brightness, legacy.current().Brightness)
+	}
+}
+
+// A change the light took succeeds, even when reading its state back fails
+func TestDaemonSetReadFails(t *testing.T) {
+	puts := 0
+	lightSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			puts++
+			return
+		}
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer lightSrv.Close()
+	host, port, _ := net.SplitHostPort(lightSrv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	record := &LightRecord{Serial: "BW001", Name: "Desk", IP: host, Port: p}
+
+	direct := &countingLight{}
+	d := testDaemon(t, newServer(testConfig(t, record)))
+	if err := d.light(record, direct).Set(context.Background(), keylight.Patch{On: keylight.Bool(true)}); err != nil {
+		t.Errorf("Set() = %v, want nil", err)
+	}
+	if puts != 1 || direct.calls != 0 {
+		t.Errorf("light changed %d times, direct calls %d; want 1 and 0", puts, direct.calls)
+	}
+	if _, err := d.light(record, direct).State(context.Background()); err == nil {
+		t.Error("State() succeeded with no state read")
+	}
+}
+
+// A reloaded config gets new handles, at the addresses it has now
+func TestServerReload(t *testing.T) {
+	old, record := newFakeLight(t, "BW001", "Desk")
+	moved, movedRecord := newFakeLight(t, "BW001", "Desk")
+	config := testConfig(t, record)
+	saveConfig(config)
+	s := newServer(config)
+
+	edited := loadConfig()
+	edited.Lights["BW001"].Port = movedRecord.Port
+	saveConfig(edited)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(getConfigPath(), later, later)
+
+	reloaded := s.currentConfig()
+	if reloaded == config {
+		t.Fatal("config not read again")
+	}
+	light, _ := reloaded.findLight("Desk")
+	if err := s.handle(light).Set(context.Background(), keylight.Patch{Brightness: keylight.Int(30)}); err != nil {
+		t.Fatal(err)
+	}
+	if old.current().Brightness != 50 || moved.current().Brightness != 30 {
+		t.Errorf("brightness = %d at the old address, %d at the new; want 50, 30", old.current().Brightness, moved.current().Brightness)
+	}
+}
+
+// countingLight counts the requests a daemonLight falls back to
+type countingLight struct {
+	keylight.Light
+	calls int
+}
+
+func (c *countingLight) Set(context.Context, keylight.Patch) error {
+	c.calls++
+	return nil
+}
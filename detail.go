@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"elgato-keylight/keylight"
+)
+
+// How long a slider in the per-light view has to stay put before its value
+// is sent, so holding an arrow key sends one request instead of dozens
+const sliderDebounce = 150 * time.Millisecond
+
+// Per-light view slider rows; each light has one of each
+const (
+	sliderBrightness = iota
+	sliderTemperature
+	slidersPerLight
+)
+
+// sliderApplyMsg fires sliderDebounce after a slider moved. It carries the
+// edit it was scheduled for; later edits make it stale.
+type sliderApplyMsg struct {
+	light *LightRecord
+	edit  int
+}
+
+// sliderAppliedMsg reports how sending a light's slider values went
+type sliderAppliedMsg struct {
+	light *LightRecord
+	edit  int
+	state keylight.State
+	err   error
+}
+
+// openDetail switches to the per-light view, starting each light's sliders
+// at its last known state
+func (m *model) openDetail() tea.Cmd {
+	m.detailView = true
+	m.detailRow = 0
+	m.sliders = make(map[string]keylight.State, len(m.lightsList))
+	m.sliderEdits = make(map[string]int)
+	m.sliderSent = make(map[string]int)
+	m.syncSliders()
+	return m.refreshStates()
+}
+
+// syncSliders moves the sliders of lights that are not being adjusted to
+// the lights' last known state
+func (m *model) syncSliders() {
+	if !m.detailView {
+		return
+	}
+	for _, light := range m.lightsList {
+		key := recordKey(light)
+		st := m.states[key]
+		if st.err == nil && !st.updated.IsZero() && m.sliderEdits[key] == m.sliderSent[key] {
+			m.sliders[key] = st.state
+		}
+	}
+}
+
+// updateDetail handles keys in the per-light view: ↑/↓ pick a slider and
+// ←/→ move it
+func (m model) updateDetail(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		m.quitting = true
+		return m, tea.Quit
+	case "tab", "esc":
+		m.detailView = false
+		return m, nil
+	}
+	if len(m.lightsList) == 0 {
+		// No sliders to move between
+		return m, nil
+	}
+
+	rows := len(m.lightsList) * slidersPerLight
+	switch msg.String() {
+	case "up", "k":
+		m.detailRow = max(m.detailRow-1, 0)
+	case "down", "j":
+		m.detailRow = max(0, min(m.detailRow+1, rows-1))
+	case "left", "h":
+		return m.moveSlider(-1)
+	case "right", "l":
+		return m.moveSlider(1)
+	}
+	return m, nil
+}
+
+// moveSlider nudges the focused slider one step and schedules sending it
+func (m model) moveSlider(direction int) (tea.Model, tea.Cmd) {
+	if len(m.lightsList) == 0 {
+		return m, nil
+	}
+	light := m.lightsList[m.detailRow/slidersPerLight]
+	key := recordKey(light)
+	state, ok := m.sliders[key]
+	if !ok {
+		// Never read, so there is nothing to start from
+		return m, nil
+	}
+
+	if m.detailRow%slidersPerLight == sliderBrightness {
+		state.Brightness = max(3, min(state.Brightness+direction*5, 100))
+	} else {
+		state.Temperature = max(2900, min(state.Temperature+direction*200, 7000))
+	}
+	return m.setSlider(light, state)
+}
+
+// setSlider moves a light's sliders and schedules sending them
+func (m model) setSlider(light *LightRecord, state keylight.State) (tea.Model, tea.Cmd) {
+	key := recordKey(light)
+	m.sliders[key] = state
+	m.sliderEdits[key]++
+
+	edit := m.sliderEdits[key]
+	return m, tea.Tick(sliderDebounce, func(time.Time) tea.Msg {
+		return sliderApplyMsg{light: light, edit: edit}
+	})
+}
+
+// applySlider sends a light's slider values if they have not moved since
+func (m model) applySlider(msg sliderApplyMsg) (tea.Model, tea.Cmd) {
+	key := recordKey(msg.light)
+	if msg.edit != m.sliderEdits[key] {
+		return m, nil
+	}
+	light, ok := m.lights[key]
+	if !ok {
+		return m, nil
+	}
+	state := m.sliders[key]
+	return m, func() tea.Msg {
+		err := light.Set(context.Background(), keylight.Patch{
+			Brightness:  keylight.Int(state.Brightness),
+			Temperature: keylight.Int(state.Temperature),
+		})
+		return sliderAppliedMsg{light: msg.light, edit: msg.edit, state: state, err: err}
+	}
+}
+
+// sliderApplied records a sent slider value in the cached state
+func (m model) sliderApplied(msg sliderAppliedMsg) (tea.Model, tea.Cmd) {
+	key := recordKey(msg.light)
+	if msg.err != nil {
+		m.message = fmt.Sprintf("✗ Error adjusting %s", msg.light.Name)
+		m.sliderSent[key] = msg.edit
+		m.syncSliders()
+		return m, nil
+	}
+	m.sliderSent[key] = msg.edit
+
+	st := m.states[key]
+	st.state.Brightness, st.state.Temperature = msg.state.Brightness, msg.state.Temperature
+	m.states[key] = st
+	m.message = fmt.Sprintf("✓ %s: %d%% / %dK", msg.light.Name, msg.state.Brightness, msg.state.Temperature)
+	return m, nil
+}
+
+// renderDetailBox shows a brightness and a temperature slider for every light
+func (m model) renderDetailBox() string {
+	var content string
+	for i, light := range m.lightsList {
+		st := m.states[recordKey(light)]
+		status := "Off"
+		switch {
+		case st.err != nil:
+			status = "Offline"
+		case st.updated.IsZero():
+			status = "…"
+		case st.state.On:
+			status = "On"
+		}
+		content += lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("(%d) %s", i+1, light.Name)) + dimStyle.Render(" ("+status+")") + "\n"
+
+		state, ok := m.sliders[recordKey(light)]
+		if !ok {
+			content += dimStyle.Render("    No state read yet") + "\n\n"
+			continue
+		}
+		content += m.renderSlider(i*slidersPerLight+sliderBrightness, "Brightness", brightnessBar(state.Brightness), fmt.Sprintf("%d%%", state.Brightness))
+		content += m.renderSlider(i*slidersPerLight+sliderTemperature, "Temperature", temperatureBar(state.Temperature), fmt.Sprintf("%dK", state.Temperature))
+		content += "\n"
+	}
+	return content
+}
+
+func (m model) renderSlider(row int, label, bar, value string) string {
+	valueStr := lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00")).Bold(true).Render(value)
+	return sliderLabel(m.detailRow == row, label) + bar + "   " + valueStr + "\n"
+}
+
+// sliderLabel renders what comes before a slider's bar in the per-light
+// view; it is the same width for every slider
+func sliderLabel(focused bool, label string) string {
+	arrow := "  "
+	labelStyle := dimStyle
+	if focused {
+		arrow = "▶ "
+		labelStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF")).Bold(true)
+	}
+	return "  " + arrow + labelStyle.Render(fmt.Sprintf("%-12s", label)) + "  "
+}
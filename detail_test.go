@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// With no lights there are no sliders; moving around does nothing
+func TestDetailNoLights(t *testing.T) {
+	m := model{config: testConfig(t), states: map[string]lightStatus{}}
+	m.openDetail()
+	m = press(t, m, "down", "down", "up", "right", "left")
+	if m.detailRow != 0 || !m.detailView {
+		t.Errorf("detailRow = %d, detailView = %v", m.detailRow, m.detailView)
+	}
+	m.renderDetailBox()
+}
+
+func TestDetailRows(t *testing.T) {
+	m := selectionModel(t)
+	m.openDetail()
+	m = press(t, m, "down", "down", "down", "down", "down", "down", "down")
+	if want := 3*slidersPerLight - 1; m.detailRow != want {
+		t.Errorf("detailRow = %d, want the last slider, %d", m.detailRow, want)
+	}
+	m = press(t, m, "up")
+	if m.detailRow != 4 {
+		t.Errorf("detailRow = %d, want 4", m.detailRow)
+	}
+	m = press(t, m, "esc")
+	if m.detailView {
+		t.Error("esc did not leave the per-light view")
+	}
+}
+
+// Sliders start at the light's state, are sent once they stop moving and
+// then become the cached state
+func TestDetailSliders(t *testing.T) {
+	desk, record := newFakeLight(t, "BW001", "Desk")
+	m := model{config: testConfig(t, record), states: map[string]lightStatus{}}
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+
+	// Nothing to start from until the light has been read
+	m.openDetail()
+	if next, cmd := m.moveSlider(1); cmd != nil || next.(model).sliderEdits["BW001"] != 0 {
+		t.Error("moved a slider with no state read")
+	}
+	if !strings.Contains(m.renderDetailBox(), "No state read yet") {
+		t.Error("slider shown before the light was read")
+	}
+	next, _ := m.Update(stateMsg{"BW001": {state: desk.current(), updated: time.Now()}})
+	m = next.(model)
+	if m.sliders["BW001"] != desk.current() {
+		t.Fatalf("sliders = %+v", m.sliders)
+	}
+
+	// Two quick moves send one request, for the last
+	m = press(t, m, "right", "right")
+	if got := m.sliders["BW001"].Brightness; got != 60 {
+		t.Errorf("slider brightness = %d, want 60", got)
+	}
+	if _, cmd := m.applySlider(sliderApplyMsg{light: record, edit: 1}); cmd != nil {
+		t.Error("sent a slider value that has moved since")
+	}
+
+	// A refresh meanwhile leaves the slider being moved alone
+	m.syncSliders()
+	if got := m.sliders["BW001"].Brightness; got != 60 {
+		t.Errorf("refresh moved the slider to %d", got)
+	}
+
+	next, cmd := m.applySlider(sliderApplyMsg{light: record, edit: 2})
+	msg := cmd()
+	if got := desk.current().Brightness; got != 60 {
+		t.Errorf("light brightness = %d, want 60", got)
+	}
+	next, _ = next.(model).Update(msg)
+	m = next.(model)
+	if m.states["BW001"].state.Brightness != 60 || m.message != "✓ Desk: 60% / 4000K" {
+		t.Errorf("state %+v, message %q", m.states["BW001"], m.message)
+	}
+
+	// A failed send puts the slider back at the last known state
+	m = press(t, m, "down", "right")
+	next, _ = m.Update(sliderAppliedMsg{light: record, edit: m.sliderEdits["BW001"], err: errors.New("timeout")})
+	m = next.(model)
+	if m.sliders["BW001"].Temperature != 4000 || m.message != "✗ Error adjusting Desk" {
+		t.Errorf("slider %+v, message %q", m.sliders["BW001"], m.message)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+
+	"elgato-keylight/keylight"
+)
+
+// discoveredLight is a light that answered an mDNS browse
+type discoveredLight struct {
+	Instance string
+	Hostname string
+	IP       string
+	Port     int
+	Info     keylight.AccessoryInfo // zero if the light could not be queried
+}
+
+// Name is what the light is called in the config: the name set in Control
+// Center if there is one, otherwise the mDNS instance name, otherwise the
+// product name.
+func (d discoveredLight) Name() string {
+	switch {
+	case d.Info.DisplayName != "":
+		return d.Info.DisplayName
+	case d.Instance != "":
+		return d.Instance
+	case d.Info.ProductName != "":
+		return d.Info.ProductName
+	}
+	return d.IP
+}
+
+func (d discoveredLight) record() *LightRecord {
+	light := &LightRecord{Port: d.Port}
+	d.update(light)
+	return light
+}
+
+func (d discoveredLight) update(light *LightRecord) {
+	if d.Info.SerialNumber != "" {
+		light.Serial = d.Info.SerialNumber
+	}
+	if !light.CustomName {
+		light.Name = d.Name()
+	}
+	light.IP = d.IP
+	light.Port = d.Port
+	// Lights found without mDNS (scan, add) keep what mDNS last told us
+	if d.Hostname != "" {
+		light.Hostname = d.Hostname
+	}
+	if d.Instance != "" {
+		light.Instance = d.Instance
+	}
+}
+
+// discoveryOptions controls an mDNS query
+type discoveryOptions struct {
+	Timeout    time.Duration
+	Interfaces []string // empty means every multicast interface
+}
+
+// newResolver creates a resolver limited to the selected interfaces
+func (o discoveryOptions) newResolver() (*zeroconf.Resolver, error) {
+	if len(o.Interfaces) == 0 {
+		return zeroconf.NewResolver(nil)
+	}
+
+	ifaces := make([]net.Interface, 0, len(o.Interfaces))
+	for _, name := range o.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %w", name, err)
+		}
+		ifaces = append(ifaces, *iface)
+	}
+	return zeroconf.NewResolver(zeroconf.SelectIfaces(ifaces))
+}
+
+// entryIP picks the address to reach an mDNS answer at: IPv4 if the light
+// has one, otherwise a routable IPv6 address, otherwise a link-local one.
+// Link-local addresses only work with a zone, which is known when a single
+// interface was selected.
+func (o discoveryOptions) entryIP(entry *zeroconf.ServiceEntry) (string, bool) {
+	if len(entry.AddrIPv4) > 0 {
+		return entry.AddrIPv4[0].String(), true
+	}
+	for _, ip := range entry.AddrIPv6 {
+		if !ip.IsLinkLocalUnicast() {
+			return ip.String(), true
+		}
+	}
+	if len(entry.AddrIPv6) > 0 {
+		ip := entry.AddrIPv6[0].String()
+		if len(o.Interfaces) == 1 {
+			ip += "%" + o.Interfaces[0]
+		}
+		return ip, true
+	}
+	return "", false
+}
+
+// browseLights browses for _elg._tcp services until the timeout expires,
+// calling onFound (if set) as each light answers
+func browseLights(opts discoveryOptions, onFound func(discoveredLight)) ([]discoveredLight, error) {
+	resolver, err := opts.newResolver()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	if err := resolver.Browse(ctx, "_elg._tcp", "local.", entries); err != nil {
+		return nil, err
+	}
+
+	// The resolver closes entries once ctx is done
+	var found []discoveredLight
+	seen := make(map[string]bool)
+	for entry := range entries {
+		ip, ok := opts.entryIP(entry)
+		if !ok || seen[entry.Instance] {
+			continue
+		}
+		seen[entry.Instance] = true
+		d := discoveredLight{
+			Instance: entry.Instance,
+			Hostname: entry.HostName,
+			IP:       ip,
+			Port:     entry.Port,
+		}
+		found = append(found, d)
+		if onFound != nil {
+			onFound(d)
+		}
+	}
+	return found, nil
+}
+
+// identifyLights fetches accessory info for each discovered light so it can
+// be matched to its config record by serial number, calling onIdentified
+// (if set) as each light answers or gives up
+func identifyLights(found []discoveredLight, onIdentified func(discoveredLight)) []discoveredLight {
+	return fanOut(context.Background(), found, func(ctx context.Context, d discoveredLight) discoveredLight {
+		addr := (&LightRecord{IP: d.IP, Port: d.Port}).Addr()
+		if info, err := client.Light(addr).Info(ctx); err == nil {
+			d.Info = info
+		}
+		if onIdentified != nil {
+			onIdentified(d)
+		}
+		return d
+	})
+}
+
+// discoveryResult summarises how a discovery changed the config
+type discoveryResult struct {
+	Added   []*LightRecord
+	Updated []*LightRecord
+	Stale   []*LightRecord // configured lights that did not answer
+	Removed []*LightRecord // only when replacing
+}
+
+// mergeDiscovery adds newly found lights and refreshes the addresses of
+// known ones. Configured lights that did not answer are kept but marked
+// stale, since they may just have been asleep during the browse.
+func (c *Config) mergeDiscovery(found []discoveredLight) discoveryResult {
+	var result discoveryResult
+	seen := make(map[*LightRecord]bool, len(found))
+	for _, d := range found {
+		light, added := c.upsertLight(d)
+		if added {
+			result.Added = append(result.Added, light)
+		} else {
+			result.Updated = append(result.Updated, light)
+		}
+		seen[light] = true
+	}
+
+	for _, light := range c.Lights {
+		if !seen[light] {
+			light.Stale = true
+			result.Stale = append(result.Stale, light)
+		}
+	}
+	return result
+}
+
+// upsertLight refreshes the record for a found light, or adds one if the
+// light is new
+func (c *Config) upsertLight(d discoveredLight) (light *LightRecord, added bool) {
+	key, light := c.matchLight(d)
+	if light == nil {
+		light = d.record()
+		added = true
+		c.Order = append(c.Order, recordKey(light))
+	} else {
+		d.update(light)
+		light.Stale = false
+		delete(c.Lights, key)
+		// Keep its position and groups if the key changed (serial now known)
+		c.renameKey(key, recordKey(light))
+	}
+	c.Lights[recordKey(light)] = light
+	return light, added
+}
+
+// replaceDiscovery replaces the configured lights with the discovered ones,
+// reusing the existing record for any light that was already known
+func (c *Config) replaceDiscovery(found []discoveredLight) discoveryResult {
+	result := c.mergeDiscovery(found)
+	for _, light := range c.Lights {
+		if light.Stale {
+			c.forgetLight(light)
+		}
+	}
+	result.Removed, result.Stale = result.Stale, nil
+	return result
+}
+
+// matchLight finds the record for a discovered light and the key it is
+// stored under: by serial number when the light could be identified,
+// otherwise by mDNS instance name. Records without a serial (from older
+// configs) also match on IP.
+func (c *Config) matchLight(d discoveredLight) (string, *LightRecord) {
+	if serial := d.Info.SerialNumber; serial != "" {
+		if light, ok := c.Lights[serial]; ok {
+			return serial, light
+		}
+	}
+	for key, light := range c.Lights {
+		if light.Serial != "" && d.Info.SerialNumber != "" {
+			continue
+		}
+		// Records added by hand, by scan or from the old config format
+		// have no instance name
+		sameInstance := light.Instance != "" && light.Instance == d.Instance
+		if sameInstance || (light.Serial == "" && light.IP == d.IP) {
+			return key, light
+		}
+	}
+	return "", nil
+}
+
+// recordKey is the key a light is stored under: its serial number, or its
+// name until the serial is known
+func recordKey(light *LightRecord) string {
+	if light.Serial != "" {
+		return light.Serial
+	}
+	return light.Name
+}
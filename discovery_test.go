@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"elgato-keylight/keylight"
+)
+
+func TestMatchLight(t *testing.T) {
+	config := &Config{Lights: map[string]*LightRecord{
+		"BW001":      {Serial: "BW001", Name: "Desk", IP: "10.0.0.5", Instance: "Elgato Key Light 1A2B"},
+		"Legacy":     {Name: "Legacy", IP: "10.0.0.6", Instance: "Elgato Key Light Air 3C4D"},
+		"Old":        {Name: "Old", IP: "10.0.0.7"},
+		"BW004":      {Serial: "BW004", Name: "Scanned", IP: "10.0.0.8"},
+		"Unresolved": {Name: "Unresolved", IP: "10.0.0.9", Instance: "Elgato Key Light Mini 5E6F"},
+	}}
+
+	tests := []struct {
+		name    string
+		found   discoveredLight
+		wantKey string
+	}{
+		{"by serial", discoveredLight{Instance: "Renamed", IP: "10.0.0.50", Info: keylight.AccessoryInfo{SerialNumber: "BW001"}}, "BW001"},
+		{"unidentified, by instance", discoveredLight{Instance: "Elgato Key Light 1A2B", IP: "10.0.0.50"}, "BW001"},
+		{"serial learned, by instance", discoveredLight{Instance: "Elgato Key Light Air 3C4D", Info: keylight.AccessoryInfo{SerialNumber: "BW002"}}, "Legacy"},
+		{"no serial stored, by IP", discoveredLight{Instance: "Elgato Key Light 7A8B", IP: "10.0.0.7"}, "Old"},
+		{"other serial, same instance", discoveredLight{Instance: "Elgato Key Light 1A2B", Info: keylight.AccessoryInfo{SerialNumber: "BW009"}}, ""},
+		{"no instance on either side", discoveredLight{IP: "10.0.0.60"}, ""},
+		{"new light", discoveredLight{Instance: "Elgato Key Light 9C0D", IP: "10.0.0.61", Info: keylight.AccessoryInfo{SerialNumber: "BW010"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, light := config.matchLight(tt.found)
+			if key != tt.wantKey {
+				t.Fatalf("matchLight() key = %q, want %q", key, tt.wantKey)
+			}
+			if (light == nil) != (tt.wantKey == "") || (light != nil && light != config.Lights[key]) {
+				t.Errorf("matchLight() light = %+v for key %q", light, key)
+			}
+		})
+	}
+}
+
+func TestMergeDiscovery(t *testing.T) {
+	desk := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5", Instance: "Elgato Key Light 1A2B", CustomName: true}
+	legacy := &LightRecord{Name: "Key Light Air", IP: "10.0.0.6", Instance: "Elgato Key Light Air 3C4D"}
+	asleep := &LightRecord{Serial: "BW003", Name: "Shelf", IP: "10.0.0.7"}
+	config := &Config{
+		Lights: map[string]*LightRecord{"BW001": desk, "Key Light Air": legacy, "BW003": asleep},
+		Order:  []string{"Key Light Air", "BW001", "BW003"},
+		Groups: map[string][]string{"desk": {"BW001", "Key Light Air"}},
+	}
+
+	result := config.mergeDiscovery([]discoveredLight{
+		{Instance: "Elgato Key Light 1A2B", IP: "10.0.0.50", Port: 9123, Info: keylight.AccessoryInfo{SerialNumber: "BW001", DisplayName: "Key Light"}},
+		{Instance: "Elgato Key Light Air 3C4D", IP: "10.0.0.6", Port: 9123, Info: keylight.AccessoryInfo{SerialNumber: "BW002", DisplayName: "Air"}},
+		{Instance: "Elgato Key Light Mini 5E6F", IP: "10.0.0.8", Port: 9123, Info: keylight.AccessoryInfo{SerialNumber: "BW004"}},
+	})
+
+	if len(result.Added) != 1 || result.Added[0].Serial != "BW004" || result.Added[0].Name != "Elgato Key Light Mini 5E6F" {
+		t.Errorf("Added = %+v", result.Added)
+	}
+	if len(result.Updated) != 2 || result.Updated[0] != desk || result.Updated[1] != legacy {
+		t.Errorf("Updated = %+v", result.Updated)
+	}
+	if len(result.Stale) != 1 || result.Stale[0] != asleep || !asleep.Stale {
+		t.Errorf("Stale = %+v", result.Stale)
+	}
+
+	// A name set by the user survives; the address follows the light
+	if desk.Name != "Desk" || desk.IP != "10.0.0.50" || desk.Stale {
+		t.Errorf("desk = %+v", desk)
+	}
+	// A record from before serials is keyed by serial once it is known,
+	// keeping its place in the order and its groups
+	if config.Lights["BW002"] != legacy || config.Lights["Key Light Air"] != nil || legacy.Name != "Air" {
+		t.Errorf("legacy record not re-keyed: %+v", config.Lights)
+	}
+	if want := []string{"BW002", "BW001", "BW003", "BW004"}; !reflect.DeepEqual(config.Order, want) {
+		t.Errorf("Order = %v, want %v", config.Order, want)
+	}
+	if want := []string{"BW001", "BW002"}; !reflect.DeepEqual(config.Groups["desk"], want) {
+		t.Errorf("group desk = %v, want %v", config.Groups["desk"], want)
+	}
+}
+
+func TestReplaceDiscovery(t *testing.T) {
+	desk := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5"}
+	asleep := &LightRecord{Serial: "BW003", Name: "Shelf", IP: "10.0.0.7"}
+	config := &Config{Lights: map[string]*LightRecord{"BW001": desk, "BW003": asleep}}
+
+	result := config.replaceDiscovery([]discoveredLight{
+		{Instance: "Elgato Key Light 1A2B", IP: "10.0.0.5", Port: 9123, Info: keylight.AccessoryInfo{SerialNumber: "BW001", DisplayName: "Desk"}},
+	})
+	if len(result.Removed) != 1 || result.Removed[0] != asleep || len(result.Stale) != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if len(config.Lights) != 1 || config.Lights["BW001"] != desk {
+		t.Errorf("Lights = %+v", config.Lights)
+	}
+}
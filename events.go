@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"elgato-keylight/keylight"
+)
+
+// Event types sent on the server's event stream
+const (
+	eventLightChanged   = "light.changed"   // a light's state differs from the last one known
+	eventLightOnline    = "light.online"    // a light answered after not answering, or for the first time
+	eventLightOffline   = "light.offline"   // a light stopped answering
+	eventDiscoveryFound = "discovery.found" // a light that is not configured showed up on the network
+)
+
+// How often idle event streams are pinged, so proxies and clients keep them
+// open
+const eventKeepAlive = 30 * time.Second
+
+// event is one message on the event stream
+type event struct {
+	Type     string           `json:"type"`
+	Time     time.Time        `json:"time"`
+	Light    *apiLight        `json:"light,omitempty"`
+	Previous *keylight.State  `json:"previous,omitempty"` // light.changed: the state before
+	Found    *discoveredEvent `json:"found,omitempty"`    // discovery.found
+}
+
+// discoveredEvent is a light found by discovery.found
+type discoveredEvent struct {
+	Name    string `json:"name"`
+	Serial  string `json:"serial,omitempty"`
+	Address string `json:"address"`
+	Product string `json:"product,omitempty"`
+}
+
+// eventHub passes events to the streams subscribed to it. A stream that
+// falls too far behind is dropped rather than holding up the others; the
+// client reconnects and reads /lights to catch up.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan event]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan event]bool)}
+}
+
+// subscribe returns a channel of events, closed when the stream is dropped,
+// and a function to unsubscribe
+func (h *eventHub) subscribe() (<-chan event, func()) {
+	ch := make(chan event, 64)
+	h.mu.Lock()
+	h.subs[ch] = true
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.subs[ch] {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) publish(e event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// stateEvents are the events for a light whose cached state went from prev
+// to next
+func stateEvents(prev, next cachedState, known bool) []string {
+	var types []string
+	switch {
+	case next.err != nil && (!known || prev.err == nil):
+		types = append(types, eventLightOffline)
+	case next.err == nil && (!known || prev.err != nil):
+		types = append(types, eventLightOnline)
+	}
+	if next.err == nil && !prev.updated.IsZero() && next.state != prev.state {
+		types = append(types, eventLightChanged)
+	}
+	return types
+}
+
+// discover browses for lights every interval until ctx is done, sending
+// discovery.found once for each light that is not configured
+func (s *server) discover(ctx context.Context, interval time.Duration) {
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		config := s.currentConfig()
+		found, err := browseLights(config.discoveryOptions(2*time.Second), nil)
+		if err != nil {
+			out.warn("⚠ Failed to discover: %v", err)
+		}
+		for _, d := range identifyLights(found, nil) {
+			_, known := config.matchLight(d)
+			id := d.Info.SerialNumber
+			if id == "" {
+				id = d.Instance
+			}
+			if known != nil || seen[id] {
+				continue
+			}
+			seen[id] = true
+			s.events.publish(event{Type: eventDiscoveryFound, Time: time.Now(), Found: &discoveredEvent{
+				Name:    d.Name(),
+				Serial:  d.Info.SerialNumber,
+				Address: (&LightRecord{IP: d.IP, Port: d.Port}).Addr(),
+				Product: d.Info.ProductName,
+			}})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Browser sources such as OBS overlays connect from pages served elsewhere;
+// the stream only reports state, so any origin may read it, over a
+// WebSocket or (with CORS) as Server-Sent Events
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// handleEvents streams events as Server-Sent Events, or over a WebSocket
+// when the request asks to upgrade
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamWebSocket(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+	ping := time.NewTicker(eventKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *server) streamWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has answered with the error
+	}
+	defer conn.Close()
+
+	// Nothing is read from the client, but reading notices it closing
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+	ping := time.NewTicker(eventKeepAlive)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+func TestStateEvents(t *testing.T) {
+	on := keylight.State{On: true, Brightness: 50, Temperature: 4000}
+	off := keylight.State{Brightness: 50, Temperature: 4000}
+	read := func(state keylight.State) cachedState {
+		return cachedState{state: state, updated: time.Now()}
+	}
+	failed := func(c cachedState) cachedState {
+		c.err = errors.New("timeout")
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		prev, next cachedState
+		known      bool
+		want       []string
+	}{
+		{"first read", cachedState{}, read(on), false, []string{eventLightOnline}},
+		{"first read fails", cachedState{}, failed(cachedState{}), false, []string{eventLightOffline}},
+		{"unchanged", read(on), read(on), true, nil},
+		{"switched off", read(on), read(off), true, []string{eventLightChanged}},
+		{"stops answering", read(on), failed(read(on)), true, []string{eventLightOffline}},
+		{"still not answering", failed(read(on)), failed(read(on)), true, nil},
+		{"back as it was", failed(read(on)), read(on), true, []string{eventLightOnline}},
+		{"back changed", failed(read(on)), read(off), true, []string{eventLightOnline, eventLightChanged}},
+		{"answers after never being read", failed(cachedState{}), read(on), true, []string{eventLightOnline}},
+	}
+	for _, tt := range tests {
+		if got := stateEvents(tt.prev, tt.next, tt.known); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: stateEvents() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Streams come and go while events are published; run with -race
+func TestEventHubUnsubscribeDuringPublish(t *testing.T) {
+	h := newEventHub()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				h.publish(event{Type: eventLightChanged})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				events, unsubscribe := h.subscribe()
+				select {
+				case <-events:
+				default:
+				}
+				unsubscribe()
+				unsubscribe() // twice is harmless
+			}
+		}()
+	}
+	wg.Wait()
+	if len(h.subs) != 0 {
+		t.Errorf("%d streams still subscribed", len(h.subs))
+	}
+}
+
+// A stream that stops reading is dropped instead of holding up the others
+func TestEventHubDropsSlowStream(t *testing.T) {
+	h := newEventHub()
+	slow, unsubscribeSlow := h.subscribe()
+	defer unsubscribeSlow()
+	fast, unsubscribeFast := h.subscribe()
+	defer unsubscribeFast()
+
+	received := 0
+	for i := 0; i < 100; i++ {
+		h.publish(event{Type: eventLightChanged})
+		<-fast
+		received++
+	}
+	if received != 100 {
+		t.Errorf("fast stream got %d events, want 100", received)
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != cap(slow) {
+		t.Errorf("slow stream got %d events before closing, want %d", n, cap(slow))
+	}
+}
+
+// Storing a state publishes what changed, with the light as it is now
+func TestServerStoreEvents(t *testing.T) {
+	record := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5"}
+	s := newServer(testConfig(t, record))
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	on := keylight.State{On: true, Brightness: 50, Temperature: 4000}
+	s.store(record, on, nil)
+	s.store(record, on, nil)
+	s.store(record, keylight.State{On: true, Brightness: 20, Temperature: 4000}, nil)
+
+	var got []string
+	for len(events) > 0 {
+		e := <-events
+		got = append(got, e.Type)
+		if e.Light == nil || e.Light.Serial != "BW001" {
+			t.Errorf("%s: light %+v", e.Type, e.Light)
+		}
+		if e.Type == eventLightChanged && (e.Previous == nil || *e.Previous != on || e.Light.State.Brightness != 20) {
+			t.Errorf("light.changed from %+v to %+v", e.Previous, e.Light.State)
+		}
+	}
+	if want := []string{eventLightOnline, eventLightChanged}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events %v, want %v", got, want)
+	}
+}
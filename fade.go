@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// Fade defaults, overridden by --fade-rate and --fade-curve
+const (
+	defaultFadeRate  = 20 // steps per second
+	maxFadeRate      = 50
+	defaultFadeCurve = "ease-in-out"
+)
+
+// easings maps --fade-curve names to functions from linear progress (0-1)
+// to eased progress
+var easings = map[string]func(float64) float64{
+	"linear":      func(t float64) float64 { return t },
+	"ease-in":     func(t float64) float64 { return t * t },
+	"ease-out":    func(t float64) float64 { return 1 - (1-t)*(1-t) },
+	"ease-in-out": func(t float64) float64 { return t * t * (3 - 2*t) },
+}
+
+// fadeOptions controls a transition. A zero Duration means no fade.
+type fadeOptions struct {
+	Duration time.Duration
+	Rate     int // steps per second
+	Curve    string
+}
+
+// parseFadeFlags removes --fade, --fade-rate and --fade-curve from args,
+// returning the options and the remaining arguments
+func parseFadeFlags(args []string) (fadeOptions, []string, error) {
+	opts := fadeOptions{Rate: defaultFadeRate, Curve: defaultFadeCurve}
+	flags, rest, err := cutOptions(args, []string{"--fade", "--fade-rate", "--fade-curve"}, nil)
+	if err != nil {
+		return opts, nil, err
+	}
+	for _, flag := range flags {
+		switch flag.name {
+		case "--fade":
+			d, err := time.ParseDuration(flag.value)
+			if err != nil || d < 0 {
+				return opts, nil, fmt.Errorf("invalid fade duration: %s", flag.value)
+			}
+			opts.Duration = d
+		case "--fade-rate":
+			rate, err := strconv.Atoi(flag.value)
+			if err != nil || rate < 1 || rate > maxFadeRate {
+				return opts, nil, fmt.Errorf("fade rate must be between 1 and %d steps per second", maxFadeRate)
+			}
+			opts.Rate = rate
+		case "--fade-curve":
+			if _, ok := easings[flag.value]; !ok {
+				return opts, nil, fmt.Errorf("unknown fade curve: %s (use linear, ease-in, ease-out or ease-in-out)", flag.value)
+			}
+			opts.Curve = flag.value
+		}
+	}
+	return opts, rest, nil
+}
+
+// lightFade is one light's part in a fade
+type lightFade struct {
+	light    keylight.Light
+	from, to keylight.State
+	err      error
+}
+
+// patchAt returns the patch for eased progress t. Lights fading on start
+// from minimum brightness and lights fading off dim to it before switching
+// off, so power changes are smooth too. The last step sets the exact target.
+func (f *lightFade) patchAt(t float64) keylight.Patch {
+	if t >= 1 || (!f.from.On && !f.to.On) {
+		return keylight.Patch{
+			On:          keylight.Bool(f.to.On),
+			Brightness:  keylight.Int(f.to.Brightness),
+			Temperature: keylight.Int(f.to.Temperature),
+		}
+	}
+
+	fromBright, toBright := f.from.Brightness, f.to.Brightness
+	if !f.from.On {
+		fromBright = keylight.MinBrightness
+	}
+	if !f.to.On {
+		toBright = keylight.MinBrightness
+	}
+	return keylight.Patch{
+		On:          keylight.Bool(true),
+		Brightness:  keylight.Int(lerp(fromBright, toBright, t)),
+		Temperature: keylight.Int(lerp(f.from.Temperature, f.to.Temperature, t)),
+	}
+}
+
+func lerp(from, to int, t float64) int {
+	return from + int(math.Round(float64(to-from)*t))
+}
+
+// fadeLights moves every light from its from state to its to state over
+// opts.Duration. Each step is sent to all lights and waits for all of them,
+// so the lights stay in lockstep; progress follows the clock, so a slow
+// light costs steps rather than stretching the fade. A light whose request
+// fails drops out of the fade with its error recorded. When ctx is
+// cancelled the lights are left where they are and ctx's error is returned.
+func fadeLights(ctx context.Context, fades []*lightFade, opts fadeOptions) error {
+	ease := easings[opts.Curve]
+	if ease == nil {
+		ease = easings[defaultFadeCurve]
+	}
+	interval := time.Second / time.Duration(max(opts.Rate, 1))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		progress := 1.0
+		if opts.Duration > 0 {
+			progress = min(float64(time.Since(start))/float64(opts.Duration), 1)
+		}
+		t := ease(progress)
+
+		fanOut(ctx, fades, func(ctx context.Context, f *lightFade) struct{} {
+			if f.err == nil {
+				f.err = f.light.Set(ctx, f.patchAt(t))
+			}
+			return struct{}{}
+		})
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if progress >= 1 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+func TestLerp(t *testing.T) {
+	tests := []struct {
+		from, to int
+		t        float64
+		want     int
+	}{
+		{10, 90, 0, 10},
+		{10, 90, 1, 90},
+		{10, 90, 0.5, 50},
+		{90, 10, 0.25, 70},
+		{0, 3, 0.5, 2}, // rounds half away from zero
+		{3, 0, 0.5, 1},
+		{2900, 7000, 0.1, 3310},
+	}
+	for _, tt := range tests {
+		if got := lerp(tt.from, tt.to, tt.t); got != tt.want {
+			t.Errorf("lerp(%d, %d, %v) = %d, want %d", tt.from, tt.to, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestPatchAt(t *testing.T) {
+	on := func(b, k int) keylight.State { return keylight.State{On: true, Brightness: b, Temperature: k} }
+	off := func(b, k int) keylight.State { return keylight.State{Brightness: b, Temperature: k} }
+
+	tests := []struct {
+		name     string
+		from, to keylight.State
+		t        float64
+		want     keylight.State
+	}{
+		{"start", on(20, 3000), on(80, 5000), 0, on(20, 3000)},
+		{"halfway", on(20, 3000), on(80, 5000), 0.5, on(50, 4000)},
+		{"end sets the target", on(20, 3000), on(80, 5000), 1, on(80, 5000)},
+		{"fading on starts dim", off(60, 4000), on(60, 4000), 0, on(keylight.MinBrightness, 4000)},
+		{"fading on, halfway", off(60, 4000), on(63, 4000), 0.5, on(33, 4000)},
+		{"fading off dims", on(63, 4000), off(63, 4000), 0.5, on(33, 4000)},
+		{"fading off ends off", on(63, 4000), off(63, 4000), 1, off(63, 4000)},
+		{"off to off jumps", off(10, 3000), off(90, 6000), 0.5, off(90, 6000)},
+	}
+	for _, tt := range tests {
+		f := &lightFade{from: tt.from, to: tt.to}
+		p := f.patchAt(tt.t)
+		got := keylight.State{On: *p.On, Brightness: *p.Brightness, Temperature: *p.Temperature}
+		if got != tt.want {
+			t.Errorf("%s: patchAt(%v) = %+v, want %+v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestParseFadeFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		want     fadeOptions
+		wantRest []string
+		wantErr  bool
+	}{
+		{[]string{"on"}, fadeOptions{Rate: defaultFadeRate, Curve: defaultFadeCurve}, []string{"on"}, false},
+		{[]string{"--fade", "2s", "on"}, fadeOptions{Duration: 2 * time.Second, Rate: defaultFadeRate, Curve: defaultFadeCurve}, []string{"on"}, false},
+		{[]string{"50", "--fade=500ms", "--fade-rate=10", "--fade-curve", "linear"}, fadeOptions{Duration: 500 * time.Millisecond, Rate: 10, Curve: "linear"}, []string{"50"}, false},
+		{[]string{"--fade"}, fadeOptions{}, nil, true},
+		{[]string{"--fade", "-1s"}, fadeOptions{}, nil, true},
+		{[]string{"--fade-rate", "0"}, fadeOptions{}, nil, true},
+		{[]string{"--fade-curve", "bounce"}, fadeOptions{}, nil, true},
+	}
+	for _, tt := range tests {
+		opts, rest, err := parseFadeFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFadeFlags(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (opts != tt.want || !reflect.DeepEqual(rest, tt.wantRest)) {
+			t.Errorf("parseFadeFlags(%q) = %+v, %q; want %+v, %q", tt.args, opts, rest, tt.want, tt.wantRest)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits for operations that talk to several lights at once
+const (
+	fanOutWorkers = 16               // lights talked to at the same time
+	fanOutTimeout = 10 * time.Second // deadline for the whole operation
+)
+
+// fanOut calls fn for every item concurrently, at most fanOutWorkers at a
+// time, and returns the results in the order of items. fn gets a context
+// that expires fanOutTimeout after the call, so one light that keeps
+// timing out and being re-resolved cannot hold up the rest for long.
+func fanOut[T, R any](ctx context.Context, items []T, fn func(context.Context, T) R) []R {
+	ctx, cancel := context.WithTimeout(ctx, fanOutTimeout)
+	defer cancel()
+
+	results := make([]R, len(items))
+	sem := make(chan struct{}, fanOutWorkers)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = fn(ctx, item)
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Results come back in the order of the items, however the calls finish
+func TestFanOutOrder(t *testing.T) {
+	items := []int{5, 4, 3, 2, 1, 0}
+	results := fanOut(context.Background(), items, func(ctx context.Context, n int) int {
+		time.Sleep(time.Duration(n) * time.Millisecond)
+		return n * 10
+	})
+	for i, n := range items {
+		if results[i] != n*10 {
+			t.Fatalf("results = %v, want each item times 10 in item order", results)
+		}
+	}
+}
+
+// No more than fanOutWorkers calls run at once
+func TestFanOutWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	fanOut(context.Background(), make([]int, 3*fanOutWorkers), func(ctx context.Context, _ int) struct{} {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return struct{}{}
+	})
+	if most != fanOutWorkers {
+		t.Errorf("%d calls ran at once, want %d", most, fanOutWorkers)
+	}
+}
+
+// Every call shares one deadline, fanOutTimeout from the start
+func TestFanOutDeadline(t *testing.T) {
+	start := time.Now()
+	deadlines := fanOut(context.Background(), []int{1, 2, 3}, func(ctx context.Context, _ int) time.Time {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Error("call has no deadline")
+		}
+		return deadline
+	})
+	for _, deadline := range deadlines {
+		if d := deadline.Sub(start); d < fanOutTimeout || d > fanOutTimeout+time.Second {
+			t.Errorf("deadline %v after the start, want %v", d, fanOutTimeout)
+		}
+	}
+}
+
+// Cancelling the caller's context reaches every call
+func TestFanOutCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	errs := fanOut(ctx, []int{1, 2, 3}, func(ctx context.Context, _ int) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+	for i, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("call %d: err = %v, want context.Canceled", i, err)
+		}
+	}
+}
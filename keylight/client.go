@@ -0,0 +1,222 @@
+package keylight
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the port the lights serve their API on.
+const DefaultPort = 9123
+
+// DefaultTimeout bounds each request when no other timeout is configured.
+const DefaultTimeout = 2 * time.Second
+
+// Client creates Light handles that share an http.Client and settings.
+// The zero value is not usable; call NewClient.
+type Client struct {
+	httpClient *http.Client
+	port       int
+	timeout    time.Duration
+	scheme     string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithPort sets the port used for addresses that do not include one.
+func WithPort(port int) Option {
+	return func(c *Client) { c.port = port }
+}
+
+// WithTimeout sets the per-request timeout. Zero disables it.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithScheme sets the URL scheme used for addresses that are not already
+// base URLs. Defaults to "http".
+func WithScheme(scheme string) Option {
+	return func(c *Client) { c.scheme = scheme }
+}
+
+// NewClient returns a Client with the given options applied.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		httpClient: http.DefaultClient,
+		port:       DefaultPort,
+		timeout:    DefaultTimeout,
+		scheme:     "http",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Light returns a handle for the device at addr. addr may be a host name,
+// an IP address, a host:port pair, or a base URL such as
+// "http://192.168.1.20:9123".
+func (c *Client) Light(addr string) Light {
+	return &httpLight{client: c, base: c.BaseURL(addr)}
+}
+
+// BaseURL returns the URL that requests for addr are made against. IPv6
+// addresses may be given bare ("fe80::1%en0") or bracketed ("[::1]:9123");
+// they are bracketed and any zone is escaped as URLs require.
+func (c *Client) BaseURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.Trim(addr, "[]"), strconv.Itoa(c.port)
+	}
+	if i := strings.LastIndex(host, "%"); i >= 0 && !strings.HasPrefix(host[i:], "%25") {
+		host = host[:i] + "%25" + host[i+1:]
+	}
+	return c.scheme + "://" + net.JoinHostPort(host, port)
+}
+
+type httpLight struct {
+	client *Client
+	base   string
+}
+
+// wireLight is a light as encoded by the device. Temperature is in the
+// device's inverted scale.
+type wireLight struct {
+	On          *int `json:"on,omitempty"`
+	Brightness  *int `json:"brightness,omitempty"`
+	Temperature *int `json:"temperature,omitempty"`
+}
+
+type wireLights struct {
+	NumberOfLights int         `json:"numberOfLights,omitempty"`
+	Lights         []wireLight `json:"lights"`
+}
+
+func (l *httpLight) State(ctx context.Context) (State, error) {
+	var resp wireLights
+	if err := l.client.do(ctx, http.MethodGet, l.base+"/elgato/lights", nil, &resp); err != nil {
+		return State{}, err
+	}
+	if len(resp.Lights) == 0 {
+		return State{}, ErrNoLights
+	}
+	w := resp.Lights[0]
+	var s State
+	if w.On != nil {
+		s.On = *w.On == 1
+	}
+	if w.Brightness != nil {
+		s.Brightness = *w.Brightness
+	}
+	if w.Temperature != nil {
+		s.Temperature = DeviceToKelvin(*w.Temperature)
+	}
+	return s, nil
+}
+
+func (l *httpLight) Set(ctx context.Context, p Patch) error {
+	var w wireLight
+	if p.On != nil {
+		on := 0
+		if *p.On {
+			on = 1
+		}
+		w.On = &on
+	}
+	if p.Brightness != nil {
+		w.Brightness = Int(*p.Brightness)
+	}
+	if p.Temperature != nil {
+		w.Temperature = Int(KelvinToDevice(*p.Temperature))
+	}
+	body := wireLights{Lights: []wireLight{w}}
+	return l.client.do(ctx, http.MethodPut, l.base+"/elgato/lights", body, nil)
+}
+
+func (l *httpLight) Toggle(ctx context.Context) (bool, error) {
+	s, err := l.State(ctx)
+	if err != nil {
+		return false, err
+	}
+	on := !s.On
+	if err := l.Set(ctx, Patch{On: &on}); err != nil {
+		return false, err
+	}
+	return on, nil
+}
+
+func (l *httpLight) Info(ctx context.Context) (AccessoryInfo, error) {
+	var info AccessoryInfo
+	if err := l.client.do(ctx, http.MethodGet, l.base+"/elgato/accessory-info", nil, &info); err != nil {
+		return AccessoryInfo{}, err
+	}
+	return info, nil
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out when it is non-nil.
+func (c *Client) do(ctx context.Context, method, url string, body, out any) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("keylight: encode request: %w", err)
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, rd)
+	if err != nil {
+		return &RequestError{Op: method, URL: url, Err: err}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// *url.Error repeats the method and URL; keep only the cause.
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &RequestError{Op: method, URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Method: method, URL: url, StatusCode: resp.StatusCode}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &RequestError{Op: "decode", URL: url, Err: err}
+	}
+	return nil
+}
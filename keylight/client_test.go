@@ -0,0 +1,169 @@
+package keylight_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"elgato-keylight/keylight"
+)
+
+// fakeDevice serves the parts of the Elgato API the client uses, keeping the
+// light in the device's own encoding
+type fakeDevice struct {
+	mu          sync.Mutex
+	on          int
+	brightness  int
+	temperature int // device scale
+	puts        []map[string]any
+}
+
+func (d *fakeDevice) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case r.URL.Path == "/elgato/lights" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]any{
+			"numberOfLights": 1,
+			"lights":         []map[string]int{{"on": d.on, "brightness": d.brightness, "temperature": d.temperature}},
+		})
+	case r.URL.Path == "/elgato/lights" && r.Method == http.MethodPut:
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad content type", http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Lights []map[string]any `json:"lights"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Lights) != 1 {
+			http.Error(w, "bad body", http.StatusBadRequest)
+			return
+		}
+		l := body.Lights[0]
+		d.puts = append(d.puts, l)
+		if v, ok := l["on"].(float64); ok {
+			d.on = int(v)
+		}
+		if v, ok := l["brightness"].(float64); ok {
+			d.brightness = int(v)
+		}
+		if v, ok := l["temperature"].(float64); ok {
+			d.temperature = int(v)
+		}
+		w.Write([]byte("{}"))
+	case r.URL.Path == "/elgato/accessory-info":
+		json.NewEncoder(w).Encode(keylight.AccessoryInfo{
+			ProductName:     "Elgato Key Light",
+			FirmwareVersion: "1.0.3",
+			SerialNumber:    "BW123",
+			DisplayName:     "Desk",
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFakeDevice(t *testing.T) (*fakeDevice, keylight.Light) {
+	t.Helper()
+	d := &fakeDevice{on: 1, brightness: 40, temperature: 250}
+	srv := httptest.NewServer(d)
+	t.Cleanup(srv.Close)
+	return d, keylight.NewClient().Light(srv.URL)
+}
+
+func TestState(t *testing.T) {
+	_, light := newFakeDevice(t)
+	s, err := light.State(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := keylight.State{On: true, Brightness: 40, Temperature: 4000}
+	if s != want {
+		t.Errorf("State() = %+v, want %+v", s, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	d, light := newFakeDevice(t)
+	err := light.Set(context.Background(), keylight.Patch{On: keylight.Bool(false), Temperature: keylight.Int(5000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.puts) != 1 {
+		t.Fatalf("got %d PUTs, want 1", len(d.puts))
+	}
+	// Fields left out of the patch are not sent
+	if _, ok := d.puts[0]["brightness"]; ok {
+		t.Errorf("brightness sent though not in the patch: %v", d.puts[0])
+	}
+	if d.on != 0 || d.temperature != 200 || d.brightness != 40 {
+		t.Errorf("device on=%d brightness=%d temperature=%d, want 0, 40, 200", d.on, d.brightness, d.temperature)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	d, light := newFakeDevice(t)
+	for _, want := range []bool{false, true} {
+		on, err := light.Toggle(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if on != want || (d.on == 1) != want {
+			t.Errorf("Toggle() = %v with device on=%d, want %v", on, d.on, want)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	_, light := newFakeDevice(t)
+	info, err := light.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SerialNumber != "BW123" || info.Name() != "Desk" {
+		t.Errorf("Info() = %+v", info)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	c := keylight.NewClient()
+	tests := []struct {
+		addr, want string
+	}{
+		{"192.168.1.20", "http://192.168.1.20:9123"},
+		{"192.168.1.20:8080", "http://192.168.1.20:8080"},
+		{"elgato-key-light.local", "http://elgato-key-light.local:9123"},
+		{"http://192.168.1.20:9123/", "http://192.168.1.20:9123"},
+		{"::1", "http://[::1]:9123"},
+		{"[::1]", "http://[::1]:9123"},
+		{"[::1]:8080", "http://[::1]:8080"},
+		{"fe80::1%en0", "http://[fe80::1%25en0]:9123"},
+		{"[fe80::1%en0]:9123", "http://[fe80::1%25en0]:9123"},
+		{"[fe80::1%25en0]:9123", "http://[fe80::1%25en0]:9123"},
+	}
+	for _, tt := range tests {
+		if got := c.BaseURL(tt.addr); got != tt.want {
+			t.Errorf("BaseURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	c = keylight.NewClient(keylight.WithPort(80), keylight.WithScheme("https"))
+	if got := c.BaseURL("fe80::1"); got != "https://[fe80::1]:80" {
+		t.Errorf("BaseURL with options = %q", got)
+	}
+}
+
+// A zone in the address reaches the request URL in a form net/url accepts
+func TestBaseURLZoneParses(t *testing.T) {
+	base := keylight.NewClient().BaseURL("fe80::1%en0")
+	req, err := http.NewRequest(http.MethodGet, base+"/elgato/lights", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.Hostname() != "fe80::1%en0" {
+		t.Errorf("Hostname() = %q, want fe80::1%%en0", req.URL.Hostname())
+	}
+}
@@ -0,0 +1,42 @@
+package keylight
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoLights is returned when a device answers with an empty light list.
+var ErrNoLights = errors.New("keylight: no lights in response")
+
+// RequestError is returned when a request could not be completed: the
+// device did not answer, the connection was refused, or the body could not
+// be decoded.
+type RequestError struct {
+	Op  string // HTTP method, or "decode"
+	URL string
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("keylight: %s %s: %v", e.Op, e.URL, e.Err)
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+// StatusError is returned when a device answers with a non-2xx status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("keylight: %s %s: API returned status %d", e.Method, e.URL, e.StatusCode)
+}
+
+// IsUnreachable reports whether err means the device could not be reached
+// at all, as opposed to answering with an error.
+func IsUnreachable(err error) bool {
+	var reqErr *RequestError
+	return errors.As(err, &reqErr) && reqErr.Op != "decode"
+}
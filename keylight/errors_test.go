@@ -0,0 +1,94 @@
+package keylight_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+func TestStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := keylight.NewClient().Light(srv.URL).State(context.Background())
+	var statusErr *keylight.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("State() error = %v, want a *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.Method != http.MethodGet {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+	if keylight.IsUnreachable(err) {
+		t.Error("IsUnreachable() = true for a light that answered")
+	}
+}
+
+func TestNoLights(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"numberOfLights":0,"lights":[]}`))
+	}))
+	defer srv.Close()
+
+	_, err := keylight.NewClient().Light(srv.URL).State(context.Background())
+	if !errors.Is(err, keylight.ErrNoLights) {
+		t.Errorf("State() error = %v, want ErrNoLights", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer srv.Close()
+
+	_, err := keylight.NewClient().Light(srv.URL).Info(context.Background())
+	var reqErr *keylight.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Op != "decode" {
+		t.Fatalf("Info() error = %v, want a decode *RequestError", err)
+	}
+	if keylight.IsUnreachable(err) {
+		t.Error("IsUnreachable() = true for an undecodable answer")
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	// A port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	err = keylight.NewClient().Light(addr).Set(context.Background(), keylight.Patch{On: keylight.Bool(true)})
+	var reqErr *keylight.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Op != http.MethodPut {
+		t.Fatalf("Set() error = %v, want a PUT *RequestError", err)
+	}
+	if !keylight.IsUnreachable(err) {
+		t.Errorf("IsUnreachable(%v) = false", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := keylight.NewClient(keylight.WithTimeout(50 * time.Millisecond))
+	_, err := c.Light(srv.URL).State(context.Background())
+	if !keylight.IsUnreachable(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("State() error = %v, want an unreachable deadline error", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Package keylight is a client for the HTTP API exposed by Elgato Key Light,
+// Key Light Air and Key Light Mini devices on port 9123.
+package keylight
+
+import "context"
+
+// Value ranges accepted by the lights.
+const (
+	MinBrightness  = 3
+	MaxBrightness  = 100
+	MinTemperature = 2900 // Kelvin
+	MaxTemperature = 7000 // Kelvin
+)
+
+// State is the current output of a light. Temperature is in Kelvin.
+type State struct {
+	On          bool `json:"on"`
+	Brightness  int  `json:"brightness"`
+	Temperature int  `json:"temperature"`
+}
+
+// AccessoryInfo is the device metadata served at /elgato/accessory-info.
+type AccessoryInfo struct {
+	ProductName         string   `json:"productName"`
+	HardwareBoardType   int      `json:"hardwareBoardType"`
+	FirmwareBuildNumber int      `json:"firmwareBuildNumber"`
+	FirmwareVersion     string   `json:"firmwareVersion"`
+	SerialNumber        string   `json:"serialNumber"`
+	DisplayName         string   `json:"displayName"`
+	Features            []string `json:"features,omitempty"`
+}
+
+// Name returns the display name set in Control Center, falling back to the
+// product name.
+func (i AccessoryInfo) Name() string {
+	if i.DisplayName != "" {
+		return i.DisplayName
+	}
+	return i.ProductName
+}
+
+// Patch describes a partial update. Nil fields are left unchanged.
+// Temperature is in Kelvin.
+type Patch struct {
+	On          *bool
+	Brightness  *int
+	Temperature *int
+}
+
+// Light is a single controllable device.
+type Light interface {
+	// State fetches the current output of the light.
+	State(ctx context.Context) (State, error)
+	// Set applies a partial update.
+	Set(ctx context.Context, p Patch) error
+	// Toggle flips the power state and returns the new value.
+	Toggle(ctx context.Context) (bool, error)
+	// Info fetches the device metadata.
+	Info(ctx context.Context) (AccessoryInfo, error)
+}
+
+// Bool returns a pointer to v, for building a Patch.
+func Bool(v bool) *bool { return &v }
+
+// Int returns a pointer to v, for building a Patch.
+func Int(v int) *int { return &v }
+
+// KelvinToDevice converts a Kelvin value to the device's inverted scale
+// (7000K=143, 2900K=344).
+func KelvinToDevice(kelvin int) int {
+	if kelvin <= 0 {
+		return 0
+	}
+	return 1000000 / kelvin
+}
+
+// DeviceToKelvin converts the device's temperature value to Kelvin.
+func DeviceToKelvin(value int) int {
+	if value <= 0 {
+		return 0
+	}
+	return 1000000 / value
+}
+
+// ClampBrightness limits v to the range accepted by the lights.
+func ClampBrightness(v int) int {
+	return clamp(v, MinBrightness, MaxBrightness)
+}
+
+// ClampTemperature limits v (Kelvin) to the range accepted by the lights.
+func ClampTemperature(v int) int {
+	return clamp(v, MinTemperature, MaxTemperature)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"elgato-keylight/keylight"
+)
+
+// lightSender coalesces the live slider values sent to one light. While a
+// request is in flight newer values replace each other in pending, and
+// only the newest is sent when the request finishes, so holding an arrow
+// key ramps the light instead of queueing stale requests.
+type lightSender struct {
+	inFlight bool
+	pending  *keylight.Patch
+}
+
+// liveSentMsg reports that a live slider value reached a light, or did not
+type liveSentMsg struct {
+	light *LightRecord
+	patch keylight.Patch
+	err   error
+}
+
+// toggleLive switches live mode, where ←/→ on the brightness and
+// temperature sliders apply the value right away
+func (m *model) toggleLive() {
+	m.config.LiveApply = !m.config.LiveApply
+	saveConfig(m.config)
+	if m.config.LiveApply {
+		m.message = "✓ Live mode on: ←/→ apply right away"
+	} else {
+		m.message = "✓ Live mode off: press Enter to apply"
+	}
+}
+
+// sendLive sends patch to every selected light through its sender
+func (m *model) sendLive(patch keylight.Patch) tea.Cmd {
+	if m.senders == nil {
+		m.senders = make(map[string]*lightSender)
+	}
+
+	var cmds []tea.Cmd
+	for _, light := range m.selectedRecords() {
+		key := recordKey(light)
+		s := m.senders[key]
+		if s == nil {
+			s = &lightSender{}
+			m.senders[key] = s
+		}
+		if s.inFlight {
+			merged := mergePatch(s.pending, patch)
+			s.pending = &merged
+			continue
+		}
+		s.inFlight = true
+		cmds = append(cmds, sendPatch(light, m.lights[key], patch))
+	}
+	return tea.Batch(cmds...)
+}
+
+func sendPatch(light *LightRecord, handle keylight.Light, patch keylight.Patch) tea.Cmd {
+	return func() tea.Msg {
+		return liveSentMsg{light: light, patch: patch, err: handle.Set(context.Background(), patch)}
+	}
+}
+
+// liveSent records a sent value in the cached state and sends the value
+// that came in meanwhile, if any
+func (m model) liveSent(msg liveSentMsg) (tea.Model, tea.Cmd) {
+	key := recordKey(msg.light)
+	if msg.err != nil {
+		m.message = fmt.Sprintf("✗ Error adjusting %s", msg.light.Name)
+	} else if st, ok := m.states[key]; ok {
+		if msg.patch.Brightness != nil {
+			st.state.Brightness = *msg.patch.Brightness
+		}
+		if msg.patch.Temperature != nil {
+			st.state.Temperature = *msg.patch.Temperature
+		}
+		m.states[key] = st
+	}
+
+	s := m.senders[key]
+	if s.pending != nil {
+		patch := *s.pending
+		s.pending = nil
+		return m, sendPatch(msg.light, m.lights[key], patch)
+	}
+	s.inFlight = false
+	saveConfig(m.config) // the slider values are now the last used ones
+	return m, nil
+}
+
+// mergePatch returns base with the fields set in patch replaced; a nil base
+// counts as empty
+func mergePatch(base *keylight.Patch, patch keylight.Patch) keylight.Patch {
+	var merged keylight.Patch
+	if base != nil {
+		merged = *base
+	}
+	if patch.On != nil {
+		merged.On = patch.On
+	}
+	if patch.Brightness != nil {
+		merged.Brightness = patch.Brightness
+	}
+	if patch.Temperature != nil {
+		merged.Temperature = patch.Temperature
+	}
+	return merged
+}
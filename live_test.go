@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"elgato-keylight/keylight"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  *keylight.Patch
+		patch keylight.Patch
+		want  keylight.Patch
+	}{
+		{"nil base",
+			nil, keylight.Patch{Brightness: keylight.Int(40)},
+			keylight.Patch{Brightness: keylight.Int(40)}},
+		{"empty patch keeps base",
+			&keylight.Patch{On: keylight.Bool(true), Temperature: keylight.Int(4000)}, keylight.Patch{},
+			keylight.Patch{On: keylight.Bool(true), Temperature: keylight.Int(4000)}},
+		{"later value wins",
+			&keylight.Patch{Brightness: keylight.Int(40), Temperature: keylight.Int(4000)}, keylight.Patch{Brightness: keylight.Int(60)},
+			keylight.Patch{Brightness: keylight.Int(60), Temperature: keylight.Int(4000)}},
+		{"fields combine",
+			&keylight.Patch{Brightness: keylight.Int(40)}, keylight.Patch{On: keylight.Bool(false), Temperature: keylight.Int(5000)},
+			keylight.Patch{On: keylight.Bool(false), Brightness: keylight.Int(40), Temperature: keylight.Int(5000)}},
+	}
+	for _, tt := range tests {
+		// DeepEqual compares the values the fields point to
+		if got := mergePatch(tt.base, tt.patch); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mergePatch() = %s, want %s", tt.name, patchString(got), patchString(tt.want))
+		}
+	}
+
+	// The pending patch is not changed in place
+	base := &keylight.Patch{Brightness: keylight.Int(40)}
+	mergePatch(base, keylight.Patch{Brightness: keylight.Int(60)})
+	if *base.Brightness != 40 {
+		t.Errorf("base changed to %d", *base.Brightness)
+	}
+}
+
+func patchString(p keylight.Patch) string {
+	s := "{"
+	if p.On != nil {
+		s += fmt.Sprintf(" on=%v", *p.On)
+	}
+	if p.Brightness != nil {
+		s += fmt.Sprintf(" brightness=%d", *p.Brightness)
+	}
+	if p.Temperature != nil {
+		s += fmt.Sprintf(" temperature=%d", *p.Temperature)
+	}
+	return s + " }"
+}
+
+// While a value is in flight newer ones are merged, and only the newest is
+// sent when it lands
+func TestSendLiveCoalesces(t *testing.T) {
+	desk, record := newFakeLight(t, "BW001", "Desk")
+	m := model{config: testConfig(t, record), states: map[string]lightStatus{"BW001": {state: desk.current()}}}
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+	m.config.LiveApply = true
+	m.focusedControl, m.brightnessValue = focusBrightness, 50
+
+	m = press(t, m, "right")
+	first := m.senders["BW001"]
+	if first == nil || !first.inFlight {
+		t.Fatal("first value not sent")
+	}
+	m = press(t, m, "right", "right", "left")
+	if first.pending == nil || *first.pending.Brightness != 60 {
+		t.Fatalf("pending = %+v, want brightness 60", first.pending)
+	}
+
+	// The first value lands; the pending one goes out
+	next, cmd := m.Update(liveSentMsg{light: record, patch: keylight.Patch{Brightness: keylight.Int(55)}})
+	m = next.(model)
+	if m.states["BW001"].state.Brightness != 55 || cmd == nil || first.pending != nil {
+		t.Fatalf("after the first value: state %+v, pending %+v", m.states["BW001"], first.pending)
+	}
+	next, cmd = m.Update(cmd())
+	m = next.(model)
+	if cmd != nil || first.inFlight || desk.current().Brightness != 60 || desk.puts != 1 {
+		t.Errorf("light at %d after %d requests, in flight %v", desk.current().Brightness, desk.puts, first.inFlight)
+	}
+	if loadConfig().LastBrightness != 60 {
+		t.Errorf("last brightness saved as %d, want 60", loadConfig().LastBrightness)
+	}
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"net"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/grandcat/zeroconf"
+
+	"elgato-keylight/keylight"
 )
 
 // Styles
@@ -61,32 +64,13 @@ var (
 				Padding(0, 1)
 )
 
-// Config structure
-type Config struct {
-	Lights             map[string]string `json:"lights"`
-	LastBrightness     int               `json:"lastBrightness"`
-	LastTemperature    int               `json:"lastTemperature"`
-	LastSelectedLight  string            `json:"lastSelectedLight"`
-}
-
-// Light state
-type LightState struct {
-	On          int `json:"on"`
-	Brightness  int `json:"brightness"`
-	Temperature int `json:"temperature"`
-}
-
-type LightsResponse struct {
-	Lights []LightState `json:"lights"`
-}
-
 // Light selection mode
 type lightMode int
 
 const (
-	allLights lightMode = iota
-	light1
-	light2
+	allLights  lightMode = iota
+	someLights           // the lights in selectedLights
+	lightGroup           // the group at selectedGroup
 )
 
 // Control focus
@@ -98,32 +82,67 @@ const (
 	focusTurnOn
 	focusBrightness
 	focusTemperature
+	focusScene     // only reachable when scenes are saved
+	focusLightList // the light list above the controls, row at listCursor
 )
 
+// How often the TUI reads the lights' state
+const statePollInterval = 5 * time.Second
+
+// lightStatus is the last known state of a light
+type lightStatus struct {
+	state   keylight.State
+	err     error     // the last read failed
+	updated time.Time // when state was read; zero until the first successful read
+}
+
 // Model
 type model struct {
-	config              *Config
-	lights              map[string]string
-	lightsList          []string // ordered list of light names
-	selectedLightMode   lightMode
-	focusedControl      controlFocus
-	brightnessValue     int
-	temperatureValue    int
-	message             string
-	quitting            bool
+	config            *Config
+	lights            map[string]keylight.Light
+	lightsList        []*LightRecord // configured lights, in display order
+	infos             map[string]keylight.AccessoryInfo
+	states            map[string]lightStatus // read in the background, keyed by light key
+	refreshing        bool                   // a state read is running
+	refreshQueued     bool                   // read again when it finishes
+	lastRefresh       time.Time
+	discovering       bool
+	discoveryEvents   <-chan tea.Msg
+	discovered        []discoveryEntry // found so far by the running discovery
+	spinnerFrame      int
+	detailView        bool                      // showing per-light sliders
+	detailRow         int                       // focused slider: light index * slidersPerLight + slider
+	sliders           map[string]keylight.State // per-light slider values, keyed by light key
+	sliderEdits       map[string]int            // slider moves per light
+	sliderSent        map[string]int            // the move last sent per light
+	senders           map[string]*lightSender   // live mode senders, keyed by light key
+	dragging          bool                      // a slider bar is being dragged with the mouse
+	groupsList        []string                  // sorted group names
+	scenesList        []string                  // sorted scene names
+	selectedLightMode lightMode
+	selectedLights    map[string]bool // light keys, when selectedLightMode is someLights
+	selectedGroup     int
+	listCursor        int // row of the light list: All, then the lights, then the groups
+	selectedScene     int
+	focusedControl    controlFocus
+	brightnessValue   int
+	temperatureValue  int
+	message           string
+	quitting          bool
+	moves             chan lightMovedMsg // lights that requests found at a new address
 }
 
 func initialModel() model {
 	config := loadConfig()
-	if config.Lights == nil {
-		config.Lights = make(map[string]string)
+	// Handles report moves to Update, the only place records are changed
+	moves := make(chan lightMovedMsg)
+	config.relocate = func(light *LightRecord, ip string, port int) {
+		go func() { moves <- lightMovedMsg{light: light, ip: ip, port: port} }()
 	}
+	lights := config.lightHandles(config.orderedLights(), nil)
 
 	// Create ordered list of lights
-	lightsList := make([]string, 0, len(config.Lights))
-	for name := range config.Lights {
-		lightsList = append(lightsList, name)
-	}
+	lightsList := config.orderedLights()
 
 	// Set defaults if not configured
 	if config.LastBrightness == 0 {
@@ -134,58 +153,266 @@ func initialModel() model {
 	}
 
 	return model{
-		config:              config,
-		lights:              config.Lights,
-		lightsList:          lightsList,
-		selectedLightMode:   allLights,
-		focusedControl:      focusToggle,
-		brightnessValue:     config.LastBrightness,
-		temperatureValue:    config.LastTemperature,
+		config:            config,
+		lights:            lights,
+		lightsList:        lightsList,
+		moves:             moves,
+		states:            make(map[string]lightStatus, len(lights)),
+		refreshing:        true, // Init starts the first read
+		groupsList:        config.groupNames(),
+		scenesList:        config.sceneNames(),
+		selectedLightMode: allLights,
+		focusedControl:    focusToggle,
+		brightnessValue:   config.LastBrightness,
+		temperatureValue:  config.LastTemperature,
+	}
+}
+
+// infoMsg carries accessory info fetched in the background, keyed by light key
+type infoMsg map[string]keylight.AccessoryInfo
+
+// lightMovedMsg reports a light that a request found at a new address
+type lightMovedMsg struct {
+	light *LightRecord
+	ip    string
+	port  int // 0 keeps the stored port
+}
+
+// waitForMove delivers the next light found at a new address
+func waitForMove(moves <-chan lightMovedMsg) tea.Cmd {
+	return func() tea.Msg {
+		return <-moves
+	}
+}
+
+// lightMoved records a light found at a new address and saves the config.
+// A light that was removed meanwhile is ignored.
+func (m *model) lightMoved(msg lightMovedMsg) {
+	light := msg.light
+	if m.config.Lights[recordKey(light)] != light {
+		return
+	}
+	oldAddr := light.Addr()
+	light.IP = msg.ip
+	light.Stale = false
+	if msg.port != 0 {
+		light.Port = msg.port
 	}
+	saveConfig(m.config)
+	m.message = fmt.Sprintf("⚠ %s moved from %s to %s", light.Name, oldAddr, light.Addr())
+}
+
+// stateMsg carries light states read in the background, keyed by light key
+type stateMsg map[string]lightStatus
+
+// controlDoneMsg reports the outcome of a change started from the controls
+type controlDoneMsg struct {
+	message     string
+	brightness  int // set on every light, so remembered as the last used; 0 if not
+	temperature int // likewise
 }
 
+// pollMsg is sent every statePollInterval to read the lights' state again
+type pollMsg time.Time
+
 func (m model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(fetchInfo(m.lights), fetchStates(m.lights), pollStates(), waitForMove(m.moves))
+}
+
+func pollStates() tea.Cmd {
+	return tea.Tick(statePollInterval, func(t time.Time) tea.Msg {
+		return pollMsg(t)
+	})
+}
+
+// fetchStates reads every light's state without blocking the UI
+func fetchStates(lights map[string]keylight.Light) tea.Cmd {
+	return func() tea.Msg {
+		keys := make([]string, 0, len(lights))
+		for key := range lights {
+			keys = append(keys, key)
+		}
+		results := fanOut(context.Background(), keys, func(ctx context.Context, key string) lightStatus {
+			state, err := lights[key].State(ctx)
+			return lightStatus{state: state, err: err, updated: time.Now()}
+		})
+
+		states := make(stateMsg, len(keys))
+		for i, key := range keys {
+			states[key] = results[i]
+		}
+		return states
+	}
+}
+
+// refreshStates starts reading the lights' state. If a read is already
+// running another one follows it, so changes made meanwhile are seen.
+func (m *model) refreshStates() tea.Cmd {
+	if m.refreshing {
+		m.refreshQueued = true
+		return nil
+	}
+	m.refreshing = true
+	return fetchStates(m.lights)
+}
+
+// fetchInfo loads accessory info for every light without blocking the UI
+func fetchInfo(lights map[string]keylight.Light) tea.Cmd {
+	return func() tea.Msg {
+		type result struct {
+			name string
+			info keylight.AccessoryInfo
+			err  error
+		}
+		names := make([]string, 0, len(lights))
+		for name := range lights {
+			names = append(names, name)
+		}
+		results := fanOut(context.Background(), names, func(ctx context.Context, name string) result {
+			info, err := lights[name].Info(ctx)
+			return result{name: name, info: info, err: err}
+		})
+
+		infos := make(infoMsg)
+		for _, r := range results {
+			if r.err == nil {
+				infos[r.name] = r.info
+			}
+		}
+		return infos
+	}
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case infoMsg:
+		m.infos = msg
+		return m, nil
+	case lightMovedMsg:
+		m.lightMoved(msg)
+		return m, waitForMove(m.moves)
+	case lightFoundMsg:
+		m.discovered = append(m.discovered, discoveryEntry{light: discoveredLight(msg)})
+		return m, waitForDiscovery(m.discoveryEvents)
+	case lightIdentifiedMsg:
+		for i := range m.discovered {
+			if m.discovered[i].light.Instance == msg.Instance {
+				m.discovered[i] = discoveryEntry{light: discoveredLight(msg), identified: true}
+			}
+		}
+		return m, waitForDiscovery(m.discoveryEvents)
+	case discoveryDoneMsg:
+		cmd := m.finishDiscovery(msg)
+		return m, cmd
+	case spinnerMsg:
+		if !m.discovering {
+			return m, nil
+		}
+		m.spinnerFrame++
+		return m, spin()
+	case stateMsg:
+		for key, st := range msg {
+			if st.err != nil {
+				// Keep the last known state of a light that stopped answering
+				st.state, st.updated = m.states[key].state, m.states[key].updated
+			}
+			m.states[key] = st
+		}
+		m.refreshing = false
+		m.lastRefresh = time.Now()
+		m.syncSliders()
+		if m.refreshQueued {
+			m.refreshQueued = false
+			cmd := m.refreshStates()
+			return m, cmd
+		}
+		return m, nil
+	case controlDoneMsg:
+		if msg.message != "" {
+			m.message = msg.message
+		}
+		if msg.brightness != 0 {
+			m.config.LastBrightness = msg.brightness
+		}
+		if msg.temperature != 0 {
+			m.config.LastTemperature = msg.temperature
+		}
+		if msg.brightness != 0 || msg.temperature != 0 {
+			saveConfig(m.config)
+		}
+		cmd := m.refreshStates()
+		return m, cmd
+	case pollMsg:
+		cmd := m.refreshStates()
+		return m, tea.Batch(cmd, pollStates())
+	case sliderApplyMsg:
+		return m.applySlider(msg)
+	case sliderAppliedMsg:
+		return m.sliderApplied(msg)
+	case liveSentMsg:
+		return m.liveSent(msg)
+	case tea.MouseMsg:
+		return m.updateMouse(msg)
 	case tea.KeyMsg:
+		if m.detailView {
+			return m.updateDetail(msg)
+		}
+
 		// Light selection shortcuts
 		switch msg.String() {
+		case "tab":
+			cmd := m.openDetail()
+			return m, cmd
+		case "L":
+			m.toggleLive()
+			return m, nil
 		case "a":
 			m.selectedLightMode = allLights
 			m.message = "✓ Controlling all lights"
 			return m, nil
-		case "1":
-			if len(m.lightsList) >= 1 {
-				m.selectedLightMode = light1
-				m.message = fmt.Sprintf("✓ Controlling %s", m.lightsList[0])
+		case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+			if i := int(msg.String()[0] - '1'); i < len(m.lightsList) {
+				m.selectOnly(m.lightsList[i])
+				m.listCursor = i + 1
 			}
 			return m, nil
-		case "2":
-			if len(m.lightsList) >= 2 {
-				m.selectedLightMode = light2
-				m.message = fmt.Sprintf("✓ Controlling %s", m.lightsList[1])
+		case "g":
+			// Cycle through groups
+			if len(m.groupsList) > 0 {
+				if m.selectedLightMode == lightGroup {
+					m.selectedGroup = (m.selectedGroup + 1) % len(m.groupsList)
+				} else {
+					m.selectedGroup = 0
+				}
+				m.selectedLightMode = lightGroup
+				m.message = fmt.Sprintf("✓ Controlling @%s", m.groupsList[m.selectedGroup])
 			}
 			return m, nil
 		case "ctrl+c", "q":
 			m.quitting = true
 			return m, tea.Quit
 		case "d":
-			m.message = "Discovering lights..."
-			go discoverLights(&m)
-			return m, nil
+			cmd := m.startDiscovery()
+			return m, cmd
+		}
+
+		if m.focusedControl == focusLightList {
+			return m.updateLightList(msg)
 		}
 
 		// Normal navigation
+		var cmd tea.Cmd
 		switch msg.String() {
 		case "up", "k":
 			// Move up through control groups
-			if m.focusedControl == focusBrightness {
+			if m.focusedControl <= focusTurnOn {
+				m.focusedControl = focusLightList // Into the light list
+			} else if m.focusedControl == focusBrightness {
 				m.focusedControl = focusToggle // Go to action buttons
 			} else if m.focusedControl == focusTemperature {
 				m.focusedControl = focusBrightness
+			} else if m.focusedControl == focusScene {
+				m.focusedControl = focusTemperature
 			}
 		case "down", "j":
 			// Move down through control groups
@@ -193,6 +420,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.focusedControl = focusBrightness // From action buttons to brightness
 			} else if m.focusedControl == focusBrightness {
 				m.focusedControl = focusTemperature
+			} else if m.focusedControl == focusTemperature && len(m.scenesList) > 0 {
+				m.focusedControl = focusScene
 			}
 		case "left", "h":
 			// Navigate between action buttons or adjust sliders
@@ -206,12 +435,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.brightnessValue < 3 {
 					m.brightnessValue = 3
 				}
+				cmd = m.adjusted()
 			} else if m.focusedControl == focusTemperature {
 				// Adjust temperature
 				m.temperatureValue -= 200
 				if m.temperatureValue < 2900 {
 					m.temperatureValue = 2900
 				}
+				cmd = m.adjusted()
+			} else if m.focusedControl == focusScene {
+				// Previous scene
+				m.selectedScene = (m.selectedScene + len(m.scenesList) - 1) % len(m.scenesList)
 			}
 		case "right", "l":
 			// Navigate between action buttons or adjust sliders
@@ -225,122 +459,274 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.brightnessValue > 100 {
 					m.brightnessValue = 100
 				}
+				cmd = m.adjusted()
 			} else if m.focusedControl == focusTemperature {
 				// Adjust temperature
 				m.temperatureValue += 200
 				if m.temperatureValue > 7000 {
 					m.temperatureValue = 7000
 				}
+				cmd = m.adjusted()
+			} else if m.focusedControl == focusScene {
+				// Next scene
+				m.selectedScene = (m.selectedScene + 1) % len(m.scenesList)
 			}
 		case "enter", " ":
 			return m.activateControl()
 		}
+		return m, cmd
 	}
 
 	return m, nil
 }
 
+// adjusted sends the focused slider's new value in live mode
+func (m *model) adjusted() tea.Cmd {
+	if !m.config.LiveApply {
+		return nil
+	}
+	if m.focusedControl == focusBrightness {
+		m.config.LastBrightness = m.brightnessValue
+		return m.sendLive(keylight.Patch{Brightness: keylight.Int(m.brightnessValue)})
+	}
+	m.config.LastTemperature = m.temperatureValue
+	return m.sendLive(keylight.Patch{Temperature: keylight.Int(m.temperatureValue)})
+}
+
+// activateControl starts the focused control's change. The lights are set
+// in the background; the result comes back as a controlDoneMsg.
 func (m model) activateControl() (tea.Model, tea.Cmd) {
+	lights := m.getSelectedLights()
 	switch m.focusedControl {
 	case focusToggle:
-		return m.toggleLights()
+		return m, toggleLights(lights)
 	case focusTurnOff:
 		// Turn off selected lights
-		ips := m.getSelectedLightIPs()
-		offState := 0
-		for _, ip := range ips {
-			setLight(ip, &offState, nil, nil)
+		return m, func() tea.Msg {
+			if failed := setLights(lights, keylight.Patch{On: keylight.Bool(false)}); failed > 0 {
+				return controlDoneMsg{message: fmt.Sprintf("✗ Error turning off %d light(s)", failed)}
+			}
+			return controlDoneMsg{message: "✓ Lights turned off"}
 		}
-		m.message = "✓ Lights turned off"
-		return m, nil
 	case focusTurnOn:
 		// Turn on selected lights
-		ips := m.getSelectedLightIPs()
-		onState := 1
-		for _, ip := range ips {
-			setLight(ip, &onState, nil, nil)
+		return m, func() tea.Msg {
+			if failed := setLights(lights, keylight.Patch{On: keylight.Bool(true)}); failed > 0 {
+				return controlDoneMsg{message: fmt.Sprintf("✗ Error turning on %d light(s)", failed)}
+			}
+			return controlDoneMsg{message: "✓ Lights turned on"}
 		}
-		m.message = "✓ Lights turned on"
-		return m, nil
 	case focusBrightness:
 		// Apply brightness to selected lights
-		ips := m.getSelectedLightIPs()
-		success := true
-		for _, ip := range ips {
-			if err := setLight(ip, nil, &m.brightnessValue, nil); err != nil {
-				m.message = fmt.Sprintf("✗ Error setting brightness")
-				success = false
-				break
+		brightness := m.brightnessValue
+		return m, func() tea.Msg {
+			if failed := setLights(lights, keylight.Patch{Brightness: keylight.Int(brightness)}); failed > 0 {
+				return controlDoneMsg{message: fmt.Sprintf("✗ Error setting brightness on %d light(s)", failed)}
 			}
+			return controlDoneMsg{message: fmt.Sprintf("✓ Brightness set to %d%%", brightness), brightness: brightness}
 		}
-		if success {
-			m.config.LastBrightness = m.brightnessValue
-			saveConfig(m.config)
-			m.message = fmt.Sprintf("✓ Brightness set to %d%%", m.brightnessValue)
-		}
-		return m, nil
 	case focusTemperature:
 		// Apply temperature to selected lights
-		ips := m.getSelectedLightIPs()
-		success := true
-		for _, ip := range ips {
-			if err := setLight(ip, nil, nil, &m.temperatureValue); err != nil {
-				m.message = fmt.Sprintf("✗ Error setting temperature")
-				success = false
-				break
+		temperature := m.temperatureValue
+		return m, func() tea.Msg {
+			if failed := setLights(lights, keylight.Patch{Temperature: keylight.Int(temperature)}); failed > 0 {
+				return controlDoneMsg{message: fmt.Sprintf("✗ Error setting temperature on %d light(s)", failed)}
 			}
+			return controlDoneMsg{message: fmt.Sprintf("✓ Temperature set to %dK", temperature), temperature: temperature}
 		}
-		if success {
-			m.config.LastTemperature = m.temperatureValue
-			saveConfig(m.config)
-			m.message = fmt.Sprintf("✓ Temperature set to %dK", m.temperatureValue)
+	case focusScene:
+		// Apply the selected scene; it sets its own lights, whatever is selected
+		name := m.scenesList[m.selectedScene]
+		changes := m.config.sceneChanges(m.config.Scenes[name], m.lights)
+		return m, func() tea.Msg {
+			failed := 0
+			for _, r := range applyScene(context.Background(), changes) {
+				if r.err != nil {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return controlDoneMsg{message: fmt.Sprintf("✗ Scene %s: %d light(s) failed", name, failed)}
+			}
+			return controlDoneMsg{message: fmt.Sprintf("✓ Scene %s applied", name)}
 		}
-		return m, nil
 	}
 	return m, nil
 }
 
-func (m model) toggleLights() (tea.Model, tea.Cmd) {
-	ips := m.getSelectedLightIPs()
-	errorCount := 0
-	successCount := 0
+// toggleLights toggles lights in the background
+func toggleLights(lights []keylight.Light) tea.Cmd {
+	return func() tea.Msg {
+		errs := fanOut(context.Background(), lights, toggleLight)
+		errorCount := 0
+		successCount := 0
 
-	for _, ip := range ips {
-		if err := toggleLight(ip); err != nil {
-			errorCount++
-		} else {
-			successCount++
+		for _, err := range errs {
+			if err != nil {
+				errorCount++
+			} else {
+				successCount++
+			}
 		}
-	}
 
-	if errorCount == 0 && successCount > 0 {
-		m.message = fmt.Sprintf("✓ %d light(s) toggled", successCount)
-	} else if errorCount > 0 {
-		m.message = fmt.Sprintf("✗ Error toggling lights")
+		if errorCount > 0 {
+			return controlDoneMsg{message: "✗ Error toggling lights"}
+		}
+		if successCount > 0 {
+			return controlDoneMsg{message: fmt.Sprintf("✓ %d light(s) toggled", successCount)}
+		}
+		return controlDoneMsg{}
 	}
+}
 
-	return m, nil
+// setLights sends patch to every light at once, returning how many failed
+func setLights(lights []keylight.Light, patch keylight.Patch) int {
+	failed := 0
+	for _, err := range fanOut(context.Background(), lights, func(ctx context.Context, light keylight.Light) error {
+		return light.Set(ctx, patch)
+	}) {
+		if err != nil {
+			failed++
+		}
+	}
+	return failed
 }
 
-func (m model) getSelectedLightIPs() []string {
-	var ips []string
+func (m model) getSelectedLights() []keylight.Light {
+	var lights []keylight.Light
+	for _, light := range m.selectedRecords() {
+		lights = append(lights, m.lights[recordKey(light)])
+	}
+	return lights
+}
 
+// selectedRecords returns the lights the controls act on
+func (m model) selectedRecords() []*LightRecord {
+	var lights []*LightRecord
 	switch m.selectedLightMode {
 	case allLights:
-		for _, ip := range m.lights {
-			ips = append(ips, ip)
+		return m.lightsList
+	case someLights:
+		for _, light := range m.lightsList {
+			if m.selectedLights[recordKey(light)] {
+				lights = append(lights, light)
+			}
+		}
+	case lightGroup:
+		for _, member := range m.selectedGroupMembers() {
+			if _, ok := m.lights[recordKey(member)]; ok {
+				lights = append(lights, member)
+			}
 		}
-	case light1:
-		if len(m.lightsList) >= 1 {
-			ips = append(ips, m.lights[m.lightsList[0]])
+	}
+	return lights
+}
+
+// updateLightList handles keys while the light list has focus: ↑/↓ move
+// the cursor, Enter selects the row under it and Space adds the light under
+// it to the selection or removes it
+func (m model) updateLightList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	rows := m.listRows()
+	m.listCursor = min(m.listCursor, rows-1)
+
+	switch msg.String() {
+	case "up", "k":
+		m.listCursor = max(m.listCursor-1, 0)
+	case "down", "j":
+		if m.listCursor < rows-1 {
+			m.listCursor++
+		} else {
+			m.focusedControl = focusToggle // Out to the action buttons
 		}
-	case light2:
-		if len(m.lightsList) >= 2 {
-			ips = append(ips, m.lights[m.lightsList[1]])
+	case "enter":
+		m.selectRow(m.listCursor, false)
+	case " ":
+		m.selectRow(m.listCursor, true)
+	}
+	return m, nil
+}
+
+// listRows is the number of rows in the light list
+func (m model) listRows() int {
+	return 1 + len(m.lightsList) + len(m.groupsList)
+}
+
+// selectRow selects what a light list row stands for. With add, a light
+// is added to the selection or removed from it instead of replacing it.
+func (m *model) selectRow(row int, add bool) {
+	light := row - 1 // index into lightsList, if the row is a light
+	group := light - len(m.lightsList)
+	switch {
+	case row == 0:
+		m.selectedLightMode = allLights
+		m.message = "✓ Controlling all lights"
+	case group >= 0:
+		m.selectedGroup = group
+		m.selectedLightMode = lightGroup
+		m.message = fmt.Sprintf("✓ Controlling @%s", m.groupsList[group])
+	case add:
+		m.toggleSelected(m.lightsList[light])
+	default:
+		m.selectOnly(m.lightsList[light])
+	}
+}
+
+// selectOnly makes a single light the selection
+func (m *model) selectOnly(light *LightRecord) {
+	m.selectedLightMode = someLights
+	m.selectedLights = map[string]bool{recordKey(light): true}
+	m.message = fmt.Sprintf("✓ Controlling %s", light.Name)
+}
+
+// toggleSelected adds a light to the selection or removes it. Starting from
+// All or a group starts a new selection; removing the last light selects
+// All again.
+func (m *model) toggleSelected(light *LightRecord) {
+	if m.selectedLightMode != someLights {
+		m.selectedLightMode = someLights
+		m.selectedLights = make(map[string]bool)
+	}
+	key := recordKey(light)
+	if m.selectedLights[key] {
+		delete(m.selectedLights, key)
+	} else {
+		m.selectedLights[key] = true
+	}
+
+	names := m.selectedNames()
+	if len(names) == 0 {
+		m.selectedLightMode = allLights
+		m.message = "✓ Controlling all lights"
+		return
+	}
+	m.message = fmt.Sprintf("✓ Controlling %s", strings.Join(names, ", "))
+}
+
+// selectedNames returns the names of the selected lights in list order,
+// when selectedLightMode is someLights
+func (m model) selectedNames() []string {
+	var names []string
+	for _, light := range m.lightsList {
+		if m.selectedLights[recordKey(light)] {
+			names = append(names, light.Name)
 		}
 	}
+	return names
+}
 
-	return ips
+// groupMembers returns the lights in a group
+func (m model) groupMembers(group string) []*LightRecord {
+	lights, _ := m.config.groupLights(group)
+	return lights
+}
+
+// selectedGroupMembers returns the lights in the selected group, or nil
+// when no group is selected
+func (m model) selectedGroupMembers() []*LightRecord {
+	if m.selectedLightMode != lightGroup || m.selectedGroup >= len(m.groupsList) {
+		return nil
+	}
+	return m.groupMembers(m.groupsList[m.selectedGroup])
 }
 
 func (m model) View() string {
@@ -351,8 +737,22 @@ func (m model) View() string {
 	return m.renderUnifiedView()
 }
 
+// viewLayout records where parts of the view start, in lines from the top
+// of the box content, for finding what a mouse click hit
+type viewLayout struct {
+	lights   int // the light list
+	controls int // the controls box
+	detail   int // the per-light sliders
+}
+
 func (m model) renderUnifiedView() string {
-	width := 97  // Content width inside box
+	view, _ := m.renderWithLayout()
+	return view
+}
+
+func (m model) renderWithLayout() (string, viewLayout) {
+	var layout viewLayout
+	width := 97 // Content width inside box
 
 	// Helper to create separator
 	separator := func() string {
@@ -364,66 +764,108 @@ func (m model) renderUnifiedView() string {
 	}
 
 	var content string
-	content += "\n"  // Top padding
+	content += "\n" // Top padding
 
 	// Title line with version and discover button
 	titleLeft := "Control Elgato Lights  v0.9.1"
 	titleRight := "(d Detect Lights)"
-	padding := width - len(titleLeft) - len(titleRight)
+	if status := m.refreshStatus(); status != "" {
+		titleRight = status + "  " + titleRight
+	}
+	padding := width - lipgloss.Width(titleLeft) - lipgloss.Width(titleRight)
 	titleLine := titleLeft + lipgloss.NewStyle().Width(padding).Render("") + titleRight
 	content += titleLine + "\n\n"
 
 	content += separator() + "\n\n"
 
-	// Light selection box
-	content += m.renderLightSelectionBox() + "\n"
+	// Lines rendered so far
+	line := func() int {
+		return strings.Count(content, "\n")
+	}
 
-	content += separator() + "\n\n"
+	if m.detailView {
+		// One set of sliders per light
+		layout.detail = line()
+		content += m.renderDetailBox()
 
-	// Control tools box
-	content += m.renderControlsBox() + "\n"
+		content += separator() + "\n\n"
 
-	content += separator() + "\n\n"
+		content += dimStyle.Render("↑/↓: sliders • ←/→: adjust (applied as you go) • Tab/Esc: back • q: quit") + "\n"
+	} else {
+		// Light selection box
+		layout.lights = line()
+		content += m.renderLightSelectionBox() + "\n"
+
+		content += separator() + "\n\n"
+
+		// Control tools box
+		layout.controls = line()
+		content += m.renderControlsBox() + "\n"
+
+		content += separator() + "\n\n"
 
-	// Help
-	help := dimStyle.Render("↑/↓: navigate rows • ←/→: buttons/adjust • Enter: apply • a: all • 1/2: select • d: discover • q: quit")
-	content += help + "\n"
+		// Help
+		help := dimStyle.Render("↑/↓: rows • ←/→: adjust • Enter: apply • Space: multi-select • a: all • 1-9: light • g: group • q: quit")
+		content += help + "\n"
+		content += dimStyle.Render("Tab: per-light sliders • L: live mode • d: discover") + "\n"
+	}
 
 	// Message
 	if m.message != "" {
 		content += successStyle.Render(m.message) + "\n"
 	}
 
-	content += "\n"  // Bottom padding
+	content += "\n" // Bottom padding
+
+	return boxStyle.Render(content), layout
+}
+
+// refreshStatus tells whether the light states shown are being refreshed
+// or have not been refreshed for a while
+func (m model) refreshStatus() string {
+	if !m.lastRefresh.IsZero() && time.Since(m.lastRefresh) > 2*statePollInterval {
+		return errorStyle.Render(fmt.Sprintf("⚠ stale, updated %s ago", time.Since(m.lastRefresh).Truncate(time.Second)))
+	}
+	if m.refreshing {
+		return dimStyle.Render("⟳ refreshing")
+	}
+	return ""
+}
 
-	return boxStyle.Render(content)
+// cursorAt highlights a light list row when the list cursor is on it
+func (m model) cursorAt(row int, style lipgloss.Style) lipgloss.Style {
+	if m.focusedControl == focusLightList && m.listCursor == row {
+		return style.Reverse(true)
+	}
+	return style
 }
 
 func (m model) renderLightSelectionBox() string {
 	var content string
 
-	// Check how many lights are on for "All Lights" indicator
-	lightsOn := 0
-	totalLights := len(m.lightsList)
-
-	for _, name := range m.lightsList {
-		ip := m.lights[name]
-		state, err := getLightState(ip)
-		if err == nil && state.On == 1 {
-			lightsOn++
+	// Count how many of the given lights are on, for the summary indicators
+	countOn := func(lights []*LightRecord) int {
+		on := 0
+		for _, light := range lights {
+			if st := m.states[recordKey(light)]; st.err == nil && st.state.On {
+				on++
+			}
 		}
+		return on
 	}
-
-	// All Lights indicator
-	var allIndicator string
-	if lightsOn == 0 {
-		allIndicator = "○" // All off
-	} else if lightsOn == totalLights {
-		allIndicator = "●" // All on
-	} else {
-		allIndicator = "◐" // Partial
+	indicatorFor := func(on, total int) string {
+		if on == 0 {
+			return "○" // All off
+		} else if on == total {
+			return "●" // All on
+		}
+		return "◐" // Partial
 	}
 
+	// Check how many lights are on for "All Lights" indicator
+	lightsOn := countOn(m.lightsList)
+	allIndicator := indicatorFor(lightsOn, len(m.lightsList))
+
 	// Selection arrow
 	allArrow := "▹ "
 	if m.selectedLightMode == allLights {
@@ -436,26 +878,32 @@ func (m model) renderLightSelectionBox() string {
 		allLineStyle = dimStyle
 	}
 
-	content += allArrow + allLineStyle.Render(fmt.Sprintf("%s - (a) All Lights", allIndicator)) + "\n"
+	content += allArrow + m.cursorAt(0, allLineStyle).Render(fmt.Sprintf("%s - (a) All Lights", allIndicator)) + "\n"
 
-	// Individual lights - show arrow when selected OR when All is selected
-	for i, name := range m.lightsList {
-		ip := m.lights[name]
-		state, err := getLightState(ip)
+	// Individual lights - show arrow when selected OR when All or its group is selected
+	selectedMembers := m.selectedGroupMembers()
+	for i, light := range m.lightsList {
+		st := m.states[recordKey(light)]
+		state := st.state
 
 		var indicator string
 		var statusText string
 		var lineStyle lipgloss.Style
 
-		if err == nil {
-			if state.On == 1 {
+		if st.updated.IsZero() && st.err == nil {
+			// Not read yet
+			indicator = "○"
+			statusText = "…"
+			lineStyle = dimStyle
+		} else if st.err == nil {
+			if state.On {
 				indicator = "●"
-				statusText = fmt.Sprintf("On / %d%% / %dK", state.Brightness, int(1000000/state.Temperature))
+				statusText = fmt.Sprintf("On / %d%% / %dK", state.Brightness, state.Temperature)
 				// Bright white for on lights
 				lineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF"))
 			} else {
 				indicator = "○"
-				statusText = fmt.Sprintf("Off / %d%% / %dK", state.Brightness, int(1000000/state.Temperature))
+				statusText = fmt.Sprintf("Off / %d%% / %dK", state.Brightness, state.Temperature)
 				// Dimmed for off lights
 				lineStyle = dimStyle
 			}
@@ -468,12 +916,45 @@ func (m model) renderLightSelectionBox() string {
 		// Show arrow when this light is selected OR when All is selected
 		arrow := "  "
 		if m.selectedLightMode == allLights ||
-		   (i == 0 && m.selectedLightMode == light1) ||
-		   (i == 1 && m.selectedLightMode == light2) {
+			(m.selectedLightMode == someLights && m.selectedLights[recordKey(light)]) ||
+			slices.Contains(selectedMembers, light) {
+			arrow = "▶ "
+		}
+
+		line := arrow + m.cursorAt(1+i, lineStyle).Render(fmt.Sprintf("%s - (%d) %s (%s)", indicator, i+1, light.Name, statusText))
+
+		// Device metadata, once loaded
+		if info, ok := m.infos[recordKey(light)]; ok {
+			line += dimStyle.Render(fmt.Sprintf("  %s · fw %s", info.ProductName, info.FirmwareVersion))
+		}
+
+		content += line + "\n"
+	}
+
+	// Groups - g cycles through them
+	for i, group := range m.groupsList {
+		members := m.groupMembers(group)
+		on := countOn(members)
+		names := make([]string, len(members))
+		for i, light := range members {
+			names[i] = light.Name
+		}
+
+		arrow := "▹ "
+		if m.selectedLightMode == lightGroup && m.selectedGroup == i {
 			arrow = "▶ "
 		}
 
-		content += arrow + lineStyle.Render(fmt.Sprintf("%s - (%d) %s (%s)", indicator, i+1, name, statusText)) + "\n"
+		lineStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF"))
+		if on == 0 {
+			lineStyle = dimStyle
+		}
+
+		content += arrow + m.cursorAt(1+len(m.lightsList)+i, lineStyle).Render(fmt.Sprintf("%s - (g) @%s: %s", indicatorFor(on, len(members)), group, strings.Join(names, ", "))) + "\n"
+	}
+
+	if m.discovering {
+		content += "\n" + m.renderDiscovery()
 	}
 
 	return content
@@ -482,24 +963,44 @@ func (m model) renderLightSelectionBox() string {
 func (m model) renderControlsBox() string {
 	var content string
 
+	// Join buttons horizontally to form a row
+	toggleBtn, turnOffBtn, turnOnBtn := m.renderActionButtons()
+	buttonsRow := lipgloss.JoinHorizontal(lipgloss.Top, toggleBtn, " ", turnOffBtn, " ", turnOnBtn)
+	content += buttonsRow + "\n"
+
+	// Brightness control
+	content += m.renderBrightnessControl() + "\n"
+
+	// Temperature control
+	content += m.renderTemperatureControl() + "\n"
+
+	// Saved scenes
+	if len(m.scenesList) > 0 {
+		content += m.renderSceneControl() + "\n"
+	}
+
+	return content
+}
+
+// renderActionButtons renders the toggle, turn off and turn on buttons,
+// labelled with what they act on
+func (m model) renderActionButtons() (toggleBtn, turnOffBtn, turnOnBtn string) {
 	// Get scope text
 	scopeText := "All"
-	if m.selectedLightMode == light1 && len(m.lightsList) >= 1 {
-		scopeText = m.lightsList[0]
-		if len(scopeText) > 15 {
-			scopeText = scopeText[:12] + "..."
-		}
-	} else if m.selectedLightMode == light2 && len(m.lightsList) >= 2 {
-		scopeText = m.lightsList[1]
-		if len(scopeText) > 15 {
-			scopeText = scopeText[:12] + "..."
-		}
+	if names := m.selectedNames(); m.selectedLightMode == someLights && len(names) == 1 {
+		scopeText = names[0]
+	} else if m.selectedLightMode == someLights {
+		scopeText = fmt.Sprintf("%d Lights", len(names))
+	} else if m.selectedLightMode == lightGroup && m.selectedGroup < len(m.groupsList) {
+		scopeText = "@" + m.groupsList[m.selectedGroup]
+	}
+	if len(scopeText) > 15 {
+		scopeText = scopeText[:12] + "..."
 	}
 
 	// Action buttons on same line - use JoinHorizontal for proper alignment
 	// Toggle button - uses thick border when focused
 	toggleText := fmt.Sprintf(" TOGGLE %s ", scopeText)
-	var toggleBtn string
 	if m.focusedControl == focusToggle {
 		toggleBtn = buttonFocusedStyle.Render(toggleText)
 	} else {
@@ -507,32 +1008,21 @@ func (m model) renderControlsBox() string {
 	}
 
 	// Turn Off button - uses thick border when focused
-	var turnOffBtn string
+	turnOffText := fmt.Sprintf(" TURN OFF %s ", scopeText)
 	if m.focusedControl == focusTurnOff {
-		turnOffBtn = buttonFocusedStyle.Render(" TURN OFF All ")
+		turnOffBtn = buttonFocusedStyle.Render(turnOffText)
 	} else {
-		turnOffBtn = buttonStyle.Render(" TURN OFF All ")
+		turnOffBtn = buttonStyle.Render(turnOffText)
 	}
 
 	// Turn On button - uses thick border when focused
-	var turnOnBtn string
+	turnOnText := fmt.Sprintf(" TURN ON %s ", scopeText)
 	if m.focusedControl == focusTurnOn {
-		turnOnBtn = buttonFocusedStyle.Render(" TURN ON ALL ")
+		turnOnBtn = buttonFocusedStyle.Render(turnOnText)
 	} else {
-		turnOnBtn = buttonStyle.Render(" TURN ON ALL ")
+		turnOnBtn = buttonStyle.Render(turnOnText)
 	}
-
-	// Join buttons horizontally to form a row
-	buttonsRow := lipgloss.JoinHorizontal(lipgloss.Top, toggleBtn, " ", turnOffBtn, " ", turnOnBtn)
-	content += buttonsRow + "\n"
-
-	// Brightness control
-	content += m.renderBrightnessControl() + "\n"
-
-	// Temperature control
-	content += m.renderTemperatureControl() + "\n"
-
-	return content
+	return toggleBtn, turnOffBtn, turnOnBtn
 }
 
 func (m model) renderControl(label string, focus controlFocus) string {
@@ -542,9 +1032,12 @@ func (m model) renderControl(label string, focus controlFocus) string {
 	return buttonStyle.Render(label)
 }
 
-func (m model) renderBrightnessControl() string {
-	barWidth := 50
-	percentage := float64(m.brightnessValue-3) / float64(100-3)
+// Slider bar width, in cells
+const barWidth = 50
+
+// brightnessBar renders a brightness as a bar shading from gray to white
+func brightnessBar(value int) string {
+	percentage := float64(value-3) / float64(100-3)
 	filled := int(percentage * float64(barWidth))
 
 	bar := ""
@@ -558,6 +1051,33 @@ func (m model) renderBrightnessControl() string {
 			bar += dimStyle.Render("░")
 		}
 	}
+	return bar
+}
+
+// temperatureBar renders a temperature as a bar shading from warm to cool
+func temperatureBar(value int) string {
+	percentage := float64(value-2900) / float64(7000-2900)
+	filled := int(percentage * float64(barWidth))
+
+	bar := ""
+	for i := 0; i < barWidth; i++ {
+		if i < filled {
+			// Temperature gradient (warm orange to cool blue)
+			tempPercent := float64(i) / float64(barWidth)
+			r := int(255 - (tempPercent * 100))
+			g := int(180 - (tempPercent * 50))
+			b := int(100 + (tempPercent * 155))
+			color := fmt.Sprintf("#%02x%02x%02x", r, g, b)
+			bar += lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render("█")
+		} else {
+			bar += dimStyle.Render("░")
+		}
+	}
+	return bar
+}
+
+func (m model) renderBrightnessControl() string {
+	bar := brightnessBar(m.brightnessValue)
 
 	var btnLabel string
 	if m.focusedControl == focusBrightness {
@@ -570,6 +1090,9 @@ func (m model) renderBrightnessControl() string {
 		Foreground(lipgloss.Color("#00FF00")).
 		Bold(true).
 		Render(fmt.Sprintf("%d%%", m.brightnessValue))
+	if m.config.LiveApply {
+		valueStr += dimStyle.Render("  live")
+	}
 
 	// Create the bar and value as a single line
 	barAndValue := "   " + bar + "      " + valueStr
@@ -579,24 +1102,7 @@ func (m model) renderBrightnessControl() string {
 }
 
 func (m model) renderTemperatureControl() string {
-	barWidth := 50
-	percentage := float64(m.temperatureValue-2900) / float64(7000-2900)
-	filled := int(percentage * float64(barWidth))
-
-	bar := ""
-	for i := 0; i < barWidth; i++ {
-		if i < filled {
-			// Temperature gradient (warm orange to cool blue)
-			tempPercent := float64(i) / float64(barWidth)
-			r := int(255 - (tempPercent * 100))
-			g := int(180 - (tempPercent * 50))
-			b := int(100 + (tempPercent * 155))
-			color := fmt.Sprintf("#%02x%02x%02x", r, g, b)
-			bar += lipgloss.NewStyle().Foreground(lipgloss.Color(color)).Render("█")
-		} else {
-			bar += dimStyle.Render("░")
-		}
-	}
+	bar := temperatureBar(m.temperatureValue)
 
 	var btnLabel string
 	if m.focusedControl == focusTemperature {
@@ -609,6 +1115,9 @@ func (m model) renderTemperatureControl() string {
 		Foreground(lipgloss.Color("#00FF00")).
 		Bold(true).
 		Render(fmt.Sprintf("%dK", m.temperatureValue))
+	if m.config.LiveApply {
+		valueStr += dimStyle.Render("  live")
+	}
 
 	// Create the bar and value as a single line
 	barAndValue := "   " + bar + "      " + valueStr
@@ -617,172 +1126,164 @@ func (m model) renderTemperatureControl() string {
 	return lipgloss.JoinHorizontal(lipgloss.Center, btnLabel, barAndValue)
 }
 
+func (m model) renderSceneControl() string {
+	var btnLabel string
+	if m.focusedControl == focusScene {
+		btnLabel = buttonFocusedStyle.Render("     Scene      ")
+	} else {
+		btnLabel = buttonStyle.Render("     Scene      ")
+	}
 
-// Config management
-func getConfigPath() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".config", "keylight", "config.json")
-}
-
-func loadConfig() *Config {
-	configPath := getConfigPath()
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return &Config{
-			Lights:          make(map[string]string),
-			LastBrightness:  50,
-			LastTemperature: 4000,
-		}
-	}
-
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return &Config{
-			Lights:          make(map[string]string),
-			LastBrightness:  50,
-			LastTemperature: 4000,
+	// Scene names, with the selected one highlighted
+	names := make([]string, len(m.scenesList))
+	for i, name := range m.scenesList {
+		if i == m.selectedScene {
+			names[i] = selectedStyle.Bold(true).Render("▶ " + name)
+		} else {
+			names[i] = dimStyle.Render(name)
 		}
 	}
 
-	return &config
-}
-
-func saveConfig(config *Config) {
-	configPath := getConfigPath()
-	os.MkdirAll(filepath.Dir(configPath), 0755)
-
-	data, _ := json.MarshalIndent(config, "", "  ")
-	os.WriteFile(configPath, data, 0644)
+	return lipgloss.JoinHorizontal(lipgloss.Center, btnLabel, "   "+strings.Join(names, "   "))
 }
 
 // Discovery
-func discoverLights(m *model) {
-	resolver, err := zeroconf.NewResolver(nil)
-	if err != nil {
-		m.message = "Error: Failed to create resolver"
-		return
-	}
+// lightFoundMsg is sent as each light answers a TUI discovery
+type lightFoundMsg discoveredLight
 
-	entries := make(chan *zeroconf.ServiceEntry)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+// lightIdentifiedMsg is sent once a found light's accessory info has been
+// read, or could not be
+type lightIdentifiedMsg discoveredLight
 
-	err = resolver.Browse(ctx, "_elg._tcp", "local.", entries)
-	if err != nil {
-		m.message = "Error: Failed to discover"
-		return
-	}
+// discoveryDoneMsg ends a TUI discovery
+type discoveryDoneMsg struct {
+	found []discoveredLight
+	err   error
+}
 
-	discovered := make(map[string]string)
-	go func() {
-		for entry := range entries {
-			if len(entry.AddrIPv4) > 0 {
-				name := entry.Instance
-				ip := entry.AddrIPv4[0].String()
-				discovered[name] = ip
-			}
-		}
-	}()
+// spinnerMsg advances the discovery spinner
+type spinnerMsg struct{}
 
-	<-ctx.Done()
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 
-	if len(discovered) > 0 {
-		m.config.Lights = discovered
-		m.lights = discovered
-		saveConfig(m.config)
-		m.message = fmt.Sprintf("✓ Discovered %d light(s)", len(discovered))
-	} else {
-		m.message = "⚠ No lights found"
-	}
+func spin() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(time.Time) tea.Msg {
+		return spinnerMsg{}
+	})
 }
 
-// API Functions
-func getLightState(ip string) (*LightState, error) {
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://%s:9123/elgato/lights", ip))
-	if err != nil {
-		return nil, err
+// discoverLights browses for lights and identifies them, sending progress
+// to events and closing it when done
+func discoverLights(opts discoveryOptions, events chan<- tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		defer close(events)
+		found, err := browseLights(opts, func(d discoveredLight) {
+			events <- lightFoundMsg(d)
+		})
+		if err != nil {
+			events <- discoveryDoneMsg{err: err}
+			return nil
+		}
+		found = identifyLights(found, func(d discoveredLight) {
+			events <- lightIdentifiedMsg(d)
+		})
+		events <- discoveryDoneMsg{found: found}
+		return nil
 	}
-	defer resp.Body.Close()
+}
 
-	var lightsResp LightsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&lightsResp); err != nil {
-		return nil, err
+// waitForDiscovery delivers the next discovery event
+func waitForDiscovery(events <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-events
+		if !ok {
+			return nil
+		}
+		return msg
 	}
+}
 
-	if len(lightsResp.Lights) > 0 {
-		return &lightsResp.Lights[0], nil
+// startDiscovery begins a discovery unless one is running
+func (m *model) startDiscovery() tea.Cmd {
+	if m.discovering {
+		return nil
 	}
-	return nil, fmt.Errorf("no lights in response")
+	events := make(chan tea.Msg)
+	m.discovering = true
+	m.discoveryEvents = events
+	m.discovered = nil
+	m.message = ""
+	return tea.Batch(
+		discoverLights(m.config.discoveryOptions(3*time.Second), events),
+		waitForDiscovery(events),
+		spin(),
+	)
 }
 
-func setLight(ip string, on *int, brightness *int, temperature *int) error {
-	payload := make(map[string]interface{})
-	lights := make([]map[string]interface{}, 1)
-	light := make(map[string]interface{})
-
-	if on != nil {
-		light["on"] = *on
-	}
-	if brightness != nil {
-		light["brightness"] = *brightness
-	}
-	if temperature != nil {
-		// Convert from Kelvin to Elgato scale (inverted: 7000K=143, 2900K=344)
-		elgatoTemp := int(1000000 / *temperature)
-		light["temperature"] = elgatoTemp
+// finishDiscovery merges what a discovery found into the config and starts
+// controlling the lights it found
+func (m *model) finishDiscovery(msg discoveryDoneMsg) tea.Cmd {
+	m.discovering = false
+	m.discovered = nil
+	if msg.err != nil {
+		m.message = fmt.Sprintf("✗ Failed to discover: %v", msg.err)
+		return nil
 	}
-
-	lights[0] = light
-	payload["lights"] = lights
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	if len(msg.found) == 0 {
+		m.message = "⚠ No lights found"
+		return nil
 	}
 
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:9123/elgato/lights", ip), bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	result := m.config.mergeDiscovery(msg.found)
+	saveConfig(m.config)
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+	m.message = fmt.Sprintf("✓ Discovered %d light(s), %d new", len(msg.found), len(result.Added))
+	return tea.Batch(fetchInfo(m.lights), m.refreshStates())
+}
 
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// discoveryEntry is a light found by the running discovery
+type discoveryEntry struct {
+	light      discoveredLight
+	identified bool
+}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("API returned status %d", resp.StatusCode)
+// renderDiscovery shows the progress of the running discovery
+func (m model) renderDiscovery() string {
+	spinner := spinnerFrames[m.spinnerFrame%len(spinnerFrames)]
+	content := fmt.Sprintf("%s Discovering lights... %d found\n", spinner, len(m.discovered))
+	for _, entry := range m.discovered {
+		d := entry.light
+		if !entry.identified {
+			content += dimStyle.Render(fmt.Sprintf("  %s %s at %s (identifying)", spinner, d.Name(), d.IP)) + "\n"
+		} else if d.Info.SerialNumber == "" {
+			content += dimStyle.Render(fmt.Sprintf("  ? %s at %s (no accessory info)", d.Name(), d.IP)) + "\n"
+		} else {
+			content += successStyle.Render(fmt.Sprintf("  ✓ %s at %s", d.Name(), d.IP)) + dimStyle.Render(fmt.Sprintf("  %s · %s", d.Info.ProductName, d.Info.SerialNumber)) + "\n"
+		}
 	}
-
-	return nil
+	return content
 }
 
-func toggleLight(ip string) error {
-	state, err := getLightState(ip)
+// API helpers
+var client = keylight.NewClient()
+
+func toggleLight(ctx context.Context, light keylight.Light) error {
+	state, err := light.State(ctx)
 	if err != nil {
 		// If we can't get state, just try to turn on
-		newState := 1
-		return setLight(ip, &newState, nil, nil)
+		return light.Set(ctx, keylight.Patch{On: keylight.Bool(true)})
 	}
 
-	newState := 0
-	if state.On == 0 {
-		newState = 1
-	}
-
-	return setLight(ip, &newState, nil, nil)
+	return light.Set(ctx, keylight.Patch{On: keylight.Bool(!state.On)})
 }
 
 // Fast toggle without status check - for quick button presses
-func toggleLightFast(ip string) error {
+func toggleLightFast(ctx context.Context, light keylight.Light) error {
 	// Retry up to 3 times for Loupedeck/automation reliability
 	var lastErr error
 	for attempt := 1; attempt <= 3; attempt++ {
-		err := toggleLightAttempt(ip)
+		_, err := light.Toggle(ctx)
 		if err == nil {
 			return nil
 		}
@@ -797,66 +1298,11 @@ func toggleLightFast(ip string) error {
 	return fmt.Errorf("failed after 3 attempts: %w", lastErr)
 }
 
-func toggleLightAttempt(ip string) error {
-	// Get current state quickly with 2 second timeout for reliability
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://%s:9123/elgato/lights", ip))
-	if err != nil {
-		return fmt.Errorf("failed to get light state: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var lightsResp LightsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&lightsResp); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if len(lightsResp.Lights) == 0 {
-		return fmt.Errorf("no lights in response")
-	}
-
-	// Toggle
-	newState := 0
-	if lightsResp.Lights[0].On == 0 {
-		newState = 1
-	}
-
-	// Build and send request inline for speed
-	payload := map[string]interface{}{
-		"lights": []map[string]interface{}{
-			{"on": newState},
-		},
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
-	}
-
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:9123/elgato/lights", ip), bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp2, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send toggle request: %w", err)
-	}
-	defer resp2.Body.Close()
-
-	if resp2.StatusCode != 200 {
-		return fmt.Errorf("API returned status %d", resp2.StatusCode)
-	}
-
-	return nil
-}
-
 func main() {
 	// Check if CLI command is provided
 	if len(os.Args) > 1 {
 		handleCLI()
-		return
+		out.exit(out.exitCode())
 	}
 
 	// No CLI args - run TUI
@@ -871,390 +1317,1007 @@ func runTUI() {
 		fmt.Println("Please wait...")
 
 		// Run discovery in blocking mode on first run
-		discovered := runDiscovery()
+		discovered := runDiscovery(config.discoveryOptions(2 * time.Second))
 		if len(discovered) == 0 {
-			fmt.Println("No lights found. Make sure they are powered on.")
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, "No lights found. Make sure they are powered on.")
+			os.Exit(exitNoLights)
 		}
 
-		config.Lights = discovered
+		config.mergeDiscovery(discovered)
 		saveConfig(config)
 		fmt.Printf("\n✓ Discovered %d light(s)\n\n", len(discovered))
 	}
 
 	// Start TUI
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	p := tea.NewProgram(initialModel(), tea.WithAltScreen(), tea.WithMouseCellMotion())
 	if _, err := p.Run(); err != nil {
-		fmt.Printf("Error: %v", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitFailed)
 	}
 }
 
-func runDiscovery() map[string]string {
-	resolver, err := zeroconf.NewResolver(nil)
+func runDiscovery(opts discoveryOptions) []discoveredLight {
+	found, err := browseLights(opts, func(d discoveredLight) {
+		out.say("Found: %s at %s", d.Instance, d.IP)
+	})
 	if err != nil {
-		fmt.Println("Error: Failed to create resolver")
+		out.warn("Error: Failed to discover: %v", err)
 		return nil
 	}
+	return identifyLights(found, nil)
+}
 
-	entries := make(chan *zeroconf.ServiceEntry)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
+func handleCLI() {
+	config := loadConfig()
 
-	err = resolver.Browse(ctx, "_elg._tcp", "local.", entries)
+	// Output and fade options may appear anywhere on the command line
+	format, cliArgs, err := parseOutputFlags(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error: Failed to discover")
-		return nil
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
+	out.format = format
+	fade, cliArgs, err := parseFadeFlags(cliArgs)
+	if err != nil {
+		out.fail(exitUsage, "%v", err)
+	}
+	os.Args = append(os.Args[:1], cliArgs...)
+	if len(os.Args) < 2 {
+		cliHelp()
+		os.Exit(exitUsage)
+	}
+	out.result.Command, out.result.Args = os.Args[1], os.Args[2:]
 
-	discovered := make(map[string]string)
-	go func() {
-		for entry := range entries {
-			if len(entry.AddrIPv4) > 0 {
-				name := entry.Instance
-				ip := entry.AddrIPv4[0].String()
-				discovered[name] = ip
-				fmt.Printf("Found: %s at %s\n", name, ip)
-			}
-		}
-	}()
+	// Check if lights are configured
+	if len(config.Lights) == 0 && os.Args[1] != "detect" && os.Args[1] != "add" && os.Args[1] != "help" {
+		out.fail(exitNoLights, "No lights configured. Please run: keylight detect")
+	}
 
-	<-ctx.Done()
-	return discovered
-}
+	command := os.Args[1]
+	args := os.Args[2:]
+	lights := config.orderedLights()
 
-func handleCLI() {
-	config := loadConfig()
+	// Commands reach the lights through the daemon when it is running
+	if command != "daemon" && command != "serve" && command != "mqtt" {
+		daemon = dialDaemon()
+	}
 
-	// Check if lights are configured
-	if len(config.Lights) == 0 && os.Args[1] != "detect" && os.Args[1] != "help" {
-		fmt.Println("No lights configured. Please run: keylight detect")
-		os.Exit(1)
+	// A leading @group narrows any command to the group's lights
+	if strings.HasPrefix(command, "@") {
+		name := command[1:]
+		group, ok := config.groupLights(name)
+		if !ok {
+			out.fail(exitNotFound, "✗ Group '%s' not found. Use 'keylight group list' to see available groups.", name)
+		}
+		if len(args) == 0 {
+			cliToggleGroup(config, name, group, fade)
+			return
+		}
+		command, args, lights = args[0], args[1:], group
+
+		switch command {
+		case "on", "off", "bright", "temp", "status", "info":
+		default:
+			out.fail(exitUsage, "Unknown command for group: %s\nAvailable commands: on, off, bright, temp, status, info", command)
+		}
 	}
 
-	command := os.Args[1]
+	// Only commands that change a light's level can fade
+	if fade.Duration > 0 {
+		action := command
+		// A light's name, even one that several lights share, is followed
+		// by the action
+		_, err := config.findLight(command)
+		if command == "scene" || err == nil || errors.Is(err, errAmbiguousName) {
+			action = ""
+			if len(args) > 0 {
+				action = args[0]
+			}
+		}
+		switch action {
+		case "on", "off", "bright", "temp", "apply":
+		default:
+			out.fail(exitUsage, "--fade works with on, off, bright, temp and scene apply")
+		}
+	}
 
 	switch command {
 	case "on":
-		cliTurnOn(config)
+		cliTurnOn(config, lights, fade)
 	case "off":
-		cliTurnOff(config)
+		cliTurnOff(config, lights, fade)
 	case "bright":
-		cliBrightness(config)
+		cliBrightness(config, lights, args, fade)
 	case "temp":
-		cliTemperature(config)
+		cliTemperature(config, lights, args, fade)
 	case "list":
 		cliList(config)
 	case "detect":
 		cliDetect()
+	case "add":
+		cliAdd(config)
+	case "forget":
+		cliForget(config)
+	case "move":
+		cliMove(config)
+	case "reorder":
+		cliReorder(config)
 	case "status":
-		cliStatus(config)
+		cliStatus(config, lights)
+	case "info":
+		cliInfo(config, lights)
+	case "group":
+		cliGroup(config, args)
+	case "scene":
+		cliScene(config, args, fade)
+	case "serve":
+		cliServe(config, args)
+	case "daemon":
+		cliDaemon(config, args)
+	case "mqtt":
+		cliMQTT(config, args)
 	case "help":
 		cliHelp()
 	default:
 		// Check if it's a light name or ID
-		cliSpecificLight(config, command)
+		cliSpecificLight(config, command, fade)
 	}
 }
 
 // CLI Commands
 
-func cliTurnOn(config *Config) {
-	// Use goroutines for parallel execution
-	type result struct {
-		name string
-		err  error
-	}
-	results := make(chan result, len(config.Lights))
+// printMoved reports a light that was found at a new address
+func printMoved(light *LightRecord, oldAddr, newAddr string) {
+	out.warn("⚠ %s moved from %s to %s", light.Name, oldAddr, newAddr)
+}
 
-	for name, ip := range config.Lights {
-		go func(n, i string) {
-			onState := 1
-			err := setLight(i, &onState, nil, nil)
-			results <- result{name: n, err: err}
-		}(name, ip)
+func cliTurnOn(config *Config, lights []*LightRecord, fade fadeOptions) {
+	if fade.Duration > 0 {
+		cliFadePower(config, lights, fade, true)
+		return
 	}
+	cliSetPower(config, lights, true)
+}
 
-	// Collect results
-	for i := 0; i < len(config.Lights); i++ {
-		r := <-results
-		if r.err != nil {
-			fmt.Printf("✗ Failed to turn on %s\n", r.name)
-		} else {
-			fmt.Printf("✓ Turned on %s\n", r.name)
-		}
+func cliTurnOff(config *Config, lights []*LightRecord, fade fadeOptions) {
+	if fade.Duration > 0 {
+		cliFadePower(config, lights, fade, false)
+		return
 	}
+	cliSetPower(config, lights, false)
 }
 
-func cliTurnOff(config *Config) {
-	// Use goroutines for parallel execution
-	type result struct {
-		name string
-		err  error
-	}
-	results := make(chan result, len(config.Lights))
+// cliEach runs op on every light at once and returns the results in light
+// order, timed and marked ok or failed by op's error
+func cliEach(config *Config, lights []*LightRecord, op func(ctx context.Context, light keylight.Light, r *lightResult) error) []lightResult {
+	return fanOut(context.Background(), lights, func(ctx context.Context, light *LightRecord) lightResult {
+		r := newLightResult(light)
+		start := time.Now()
+		r.done(start, op(ctx, config.lightHandle(light, printMoved), &r))
+		return r
+	})
+}
 
-	for name, ip := range config.Lights {
-		go func(n, i string) {
-			offState := 0
-			err := setLight(i, &offState, nil, nil)
-			results <- result{name: n, err: err}
-		}(name, ip)
+// cliSetPower turns lights on or off
+func cliSetPower(config *Config, lights []*LightRecord, on bool) {
+	word := "off"
+	if on {
+		word = "on"
 	}
 
-	// Collect results
-	for i := 0; i < len(config.Lights); i++ {
-		r := <-results
-		if r.err != nil {
-			fmt.Printf("✗ Failed to turn off %s\n", r.name)
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		if err := light.Set(ctx, keylight.Patch{On: keylight.Bool(on)}); err != nil {
+			return err
+		}
+		r.State = out.stateAfter(ctx, light)
+		return nil
+	})
+	for _, r := range results {
+		if r.OK {
+			out.light(r, "✓ Turned %s %s", word, r.Name)
 		} else {
-			fmt.Printf("✓ Turned off %s\n", r.name)
+			out.light(r, "✗ Failed to turn %s %s", word, r.Name)
 		}
 	}
 }
 
-func cliBrightness(config *Config) {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: keylight bright [+|-|=|value]")
-		os.Exit(1)
+func cliBrightness(config *Config, lights []*LightRecord, args []string, fade fadeOptions) {
+	if len(args) < 1 {
+		out.fail(exitUsage, "Usage: keylight bright [+|-|=|value]")
 	}
 
-	action := os.Args[2]
+	describe := func(name string, s keylight.State) string {
+		return fmt.Sprintf("%s brightness: %d%%", name, s.Brightness)
+	}
+	action := args[0]
 
 	switch action {
-	case "+":
-		// Increase brightness by 5%
-		for name, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", name)
-				continue
-			}
-			newBright := state.Brightness + 5
-			if newBright > 100 {
-				newBright = 100
-			}
-			if err := setLight(ip, nil, &newBright, nil); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", name)
-			} else {
-				fmt.Printf("✓ %s brightness: %d%%\n", name, newBright)
-			}
+	case "+", "-":
+		// Nudge brightness by 5%
+		step := 5
+		if action == "-" {
+			step = -5
 		}
-	case "-":
-		// Decrease brightness by 5%
-		for name, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", name)
-				continue
-			}
-			newBright := state.Brightness - 5
-			if newBright < 3 {
-				newBright = 3
-			}
-			if err := setLight(ip, nil, &newBright, nil); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", name)
-			} else {
-				fmt.Printf("✓ %s brightness: %d%%\n", name, newBright)
-			}
+		adjust := func(b int) int { return keylight.ClampBrightness(b + step) }
+		if fade.Duration > 0 {
+			cliFadeBrightness(config, lights, fade, adjust)
+			return
 		}
+		cliNudge(config, lights, func(s keylight.State) keylight.State {
+			s.Brightness = adjust(s.Brightness)
+			return s
+		}, describe)
 	case "=":
 		// Equalize all lights to the average brightness
+		states := readStates(config, lights)
+		if len(states) == 0 {
+			out.fail(exitFailed, "✗ Could not read any lights")
+		}
 		totalBright := 0
-		count := 0
-		for _, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err == nil {
-				totalBright += state.Brightness
-				count++
-			}
+		for _, state := range states {
+			totalBright += state.Brightness
 		}
-		if count == 0 {
-			fmt.Println("✗ Could not read any lights")
+		avgBright := totalBright / len(states)
+		out.say("Setting all lights to %d%%", avgBright)
+		if fade.Duration > 0 {
+			cliFadeBrightness(config, lights, fade, func(int) int { return avgBright })
 			return
 		}
-		avgBright := totalBright / count
-		fmt.Printf("Setting all lights to %d%%\n", avgBright)
-		for name, ip := range config.Lights {
-			if err := setLight(ip, nil, &avgBright, nil); err != nil {
-				fmt.Printf("✗ Failed to set %s\n", name)
-			} else {
-				fmt.Printf("✓ %s brightness: %d%%\n", name, avgBright)
-			}
-		}
+		cliSetAll(config, lights, keylight.Patch{Brightness: &avgBright}, describe)
 	default:
 		// Set specific value
 		var brightness int
 		n, err := fmt.Sscanf(action, "%d", &brightness)
-		if n == 1 && err == nil {
-			if brightness < 3 || brightness > 100 {
-				fmt.Println("Brightness must be between 3 and 100")
-				os.Exit(1)
-			}
-			for name, ip := range config.Lights {
-				if err := setLight(ip, nil, &brightness, nil); err != nil {
-					fmt.Printf("✗ Failed to set %s\n", name)
-				} else {
-					fmt.Printf("✓ %s brightness: %d%%\n", name, brightness)
-				}
-			}
-			config.LastBrightness = brightness
-			saveConfig(config)
+		if n != 1 || err != nil {
+			out.fail(exitUsage, "Invalid brightness value")
+		}
+		if brightness < keylight.MinBrightness || brightness > keylight.MaxBrightness {
+			out.fail(exitUsage, "Brightness must be between 3 and 100")
+		}
+		if fade.Duration > 0 {
+			cliFadeBrightness(config, lights, fade, func(int) int { return brightness })
 		} else {
-			fmt.Println("Invalid brightness value")
-			os.Exit(1)
+			cliSetAll(config, lights, keylight.Patch{Brightness: &brightness}, describe)
 		}
+		config.LastBrightness = brightness
+		saveConfig(config)
 	}
 }
 
-func cliTemperature(config *Config) {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: keylight temp [+|-|=|value]")
-		os.Exit(1)
+func cliTemperature(config *Config, lights []*LightRecord, args []string, fade fadeOptions) {
+	if len(args) < 1 {
+		out.fail(exitUsage, "Usage: keylight temp [+|-|=|value]")
 	}
 
-	action := os.Args[2]
+	describe := func(name string, s keylight.State) string {
+		return fmt.Sprintf("%s temperature: %dK", name, s.Temperature)
+	}
+	action := args[0]
 
 	switch action {
-	case "+":
-		// Increase temperature by 200K
-		for name, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", name)
-				continue
-			}
-			currentTemp := int(1000000 / state.Temperature)
-			newTemp := currentTemp + 200
-			if newTemp > 7000 {
-				newTemp = 7000
-			}
-			if err := setLight(ip, nil, nil, &newTemp); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", name)
-			} else {
-				fmt.Printf("✓ %s temperature: %dK\n", name, newTemp)
-			}
+	case "+", "-":
+		// Nudge temperature by 200K
+		step := 200
+		if action == "-" {
+			step = -200
 		}
-	case "-":
-		// Decrease temperature by 200K
-		for name, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", name)
-				continue
-			}
-			currentTemp := int(1000000 / state.Temperature)
-			newTemp := currentTemp - 200
-			if newTemp < 2900 {
-				newTemp = 2900
-			}
-			if err := setLight(ip, nil, nil, &newTemp); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", name)
-			} else {
-				fmt.Printf("✓ %s temperature: %dK\n", name, newTemp)
-			}
+		adjust := func(t int) int { return keylight.ClampTemperature(t + step) }
+		if fade.Duration > 0 {
+			cliFadeTemperature(config, lights, fade, adjust)
+			return
 		}
+		cliNudge(config, lights, func(s keylight.State) keylight.State {
+			s.Temperature = adjust(s.Temperature)
+			return s
+		}, describe)
 	case "=":
 		// Equalize all lights to the average temperature
+		states := readStates(config, lights)
+		if len(states) == 0 {
+			out.fail(exitFailed, "✗ Could not read any lights")
+		}
 		totalTemp := 0
-		count := 0
-		for _, ip := range config.Lights {
-			state, err := getLightState(ip)
-			if err == nil {
-				totalTemp += int(1000000 / state.Temperature)
-				count++
-			}
+		for _, state := range states {
+			totalTemp += state.Temperature
 		}
-		if count == 0 {
-			fmt.Println("✗ Could not read any lights")
+		avgTemp := totalTemp / len(states)
+		out.say("Setting all lights to %dK", avgTemp)
+		if fade.Duration > 0 {
+			cliFadeTemperature(config, lights, fade, func(int) int { return avgTemp })
 			return
 		}
-		avgTemp := totalTemp / count
-		fmt.Printf("Setting all lights to %dK\n", avgTemp)
-		for name, ip := range config.Lights {
-			if err := setLight(ip, nil, nil, &avgTemp); err != nil {
-				fmt.Printf("✗ Failed to set %s\n", name)
-			} else {
-				fmt.Printf("✓ %s temperature: %dK\n", name, avgTemp)
-			}
-		}
+		cliSetAll(config, lights, keylight.Patch{Temperature: &avgTemp}, describe)
 	default:
 		// Set specific value
 		var temperature int
 		n, err := fmt.Sscanf(action, "%d", &temperature)
-		if n == 1 && err == nil {
-			if temperature < 2900 || temperature > 7000 {
-				fmt.Println("Temperature must be between 2900K and 7000K")
-				os.Exit(1)
-			}
-			for name, ip := range config.Lights {
-				if err := setLight(ip, nil, nil, &temperature); err != nil {
-					fmt.Printf("✗ Failed to set %s\n", name)
-				} else {
-					fmt.Printf("✓ %s temperature: %dK\n", name, temperature)
-				}
-			}
-			config.LastTemperature = temperature
-			saveConfig(config)
+		if n != 1 || err != nil {
+			out.fail(exitUsage, "Invalid temperature value")
+		}
+		if temperature < keylight.MinTemperature || temperature > keylight.MaxTemperature {
+			out.fail(exitUsage, "Temperature must be between 2900K and 7000K")
+		}
+		if fade.Duration > 0 {
+			cliFadeTemperature(config, lights, fade, func(int) int { return temperature })
+		} else {
+			cliSetAll(config, lights, keylight.Patch{Temperature: &temperature}, describe)
+		}
+		config.LastTemperature = temperature
+		saveConfig(config)
+	}
+}
+
+// readStates reads the lights that answer, for equalizing
+func readStates(config *Config, lights []*LightRecord) []keylight.State {
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		state, err := light.State(ctx)
+		r.State = &state
+		return err
+	})
+	var states []keylight.State
+	for _, r := range results {
+		if r.OK {
+			states = append(states, *r.State)
+		}
+	}
+	return states
+}
+
+// cliNudge moves each light from its current state to the state step
+// returns for it
+func cliNudge(config *Config, lights []*LightRecord, step func(keylight.State) keylight.State, describe func(name string, state keylight.State) string) {
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		state, err := light.State(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Only send what changed
+		next := step(state)
+		var patch keylight.Patch
+		if next.Brightness != state.Brightness {
+			patch.Brightness = &next.Brightness
+		}
+		if next.Temperature != state.Temperature {
+			patch.Temperature = &next.Temperature
+		}
+		if err := light.Set(ctx, patch); err != nil {
+			return err
+		}
+		r.State = &next
+		return nil
+	})
+	for _, r := range results {
+		if r.OK {
+			out.light(r, "✓ %s", describe(r.Name, *r.State))
 		} else {
-			fmt.Println("Invalid temperature value")
-			os.Exit(1)
+			out.light(r, "✗ Failed to adjust %s", r.Name)
 		}
 	}
 }
 
+// cliSetAll sets the same values on each light
+func cliSetAll(config *Config, lights []*LightRecord, patch keylight.Patch, describe func(name string, state keylight.State) string) {
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		if err := light.Set(ctx, patch); err != nil {
+			return err
+		}
+		r.State = out.stateAfter(ctx, light)
+		return nil
+	})
+
+	// Describe what was set, whether or not the state was read back
+	var set keylight.State
+	if patch.Brightness != nil {
+		set.Brightness = *patch.Brightness
+	}
+	if patch.Temperature != nil {
+		set.Temperature = *patch.Temperature
+	}
+	for _, r := range results {
+		if r.OK {
+			out.light(r, "✓ %s", describe(r.Name, set))
+		} else {
+			out.light(r, "✗ Failed to set %s", r.Name)
+		}
+	}
+}
+
+// cliFade fades lights from their current state to the state target returns
+// for each, then reports each light with describe. Ctrl-C stops the fade and
+// leaves the lights where they are.
+func cliFade(config *Config, lights []*LightRecord, fade fadeOptions, target func(*LightRecord, keylight.State) keylight.State, describe func(name string, state keylight.State) string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Every light starts from where it is now
+	start := time.Now()
+	fades := fanOut(ctx, lights, func(ctx context.Context, light *LightRecord) *lightFade {
+		f := &lightFade{light: config.lightHandle(light, printMoved)}
+		if f.from, f.err = f.light.State(ctx); f.err == nil {
+			f.to = target(light, f.from)
+		}
+		return f
+	})
+	unread := make([]bool, len(fades))
+	for i, f := range fades {
+		unread[i] = f.err != nil
+	}
+
+	if err := fadeLights(ctx, fades, fade); err != nil {
+		out.fail(exitCancelled, "\n⚠ Fade cancelled, lights left where they were")
+	}
+
+	for i, f := range fades {
+		r := newLightResult(lights[i])
+		r.done(start, f.err)
+		switch {
+		case unread[i]:
+			out.light(r, "✗ Failed to get state for %s", r.Name)
+		case f.err != nil:
+			out.light(r, "✗ Failed to fade %s", r.Name)
+		default:
+			r.State = &f.to
+			out.light(r, "✓ %s", describe(r.Name, f.to))
+		}
+	}
+}
+
+// cliFadePower fades lights on from minimum brightness, or down to it and off
+func cliFadePower(config *Config, lights []*LightRecord, fade fadeOptions, on bool) {
+	verb := "Turned off"
+	if on {
+		verb = "Turned on"
+	}
+	cliFade(config, lights, fade, func(_ *LightRecord, s keylight.State) keylight.State {
+		s.On = on
+		return s
+	}, func(name string, _ keylight.State) string {
+		return verb + " " + name
+	})
+}
+
+// cliFadeBrightness fades each light to the brightness adjust returns for it
+func cliFadeBrightness(config *Config, lights []*LightRecord, fade fadeOptions, adjust func(int) int) {
+	cliFade(config, lights, fade, func(_ *LightRecord, s keylight.State) keylight.State {
+		s.Brightness = adjust(s.Brightness)
+		return s
+	}, func(name string, s keylight.State) string {
+		return fmt.Sprintf("%s brightness: %d%%", name, s.Brightness)
+	})
+}
+
+// cliFadeTemperature fades each light to the temperature adjust returns for it
+func cliFadeTemperature(config *Config, lights []*LightRecord, fade fadeOptions, adjust func(int) int) {
+	cliFade(config, lights, fade, func(_ *LightRecord, s keylight.State) keylight.State {
+		s.Temperature = adjust(s.Temperature)
+		return s
+	}, func(name string, s keylight.State) string {
+		return fmt.Sprintf("%s temperature: %dK", name, s.Temperature)
+	})
+}
+
 func cliList(config *Config) {
 	if len(config.Lights) == 0 {
-		fmt.Println("No lights configured. Run: keylight detect")
+		out.say("No lights configured. Run: keylight detect")
 		return
 	}
 
-	fmt.Println("Configured lights:")
-	i := 1
-	for name, ip := range config.Lights {
-		fmt.Printf("  %d. %s (%s)\n", i, name, ip)
-		i++
+	out.say("Configured lights:")
+	for i, light := range config.orderedLights() {
+		r := newLightResult(light)
+		r.Index, r.Stale = i+1, light.Stale
+		stale := ""
+		if light.Stale {
+			stale = " [stale]"
+		}
+		out.light(r, "  %d. %s (%s)%s", i+1, light.Name, light.Addr(), stale)
 	}
 }
 
-func cliDetect() {
-	fmt.Println("Discovering lights...")
-	discovered := runDiscovery()
+func cliMove(config *Config) {
+	if len(os.Args) < 4 {
+		out.fail(exitUsage, "Usage: keylight move <light> <position>")
+	}
 
-	if len(discovered) == 0 {
-		fmt.Println("✗ No lights found")
+	light, err := config.findLight(os.Args[2])
+	if err != nil {
+		out.fail(lookupFailure(err), "✗ %v", err)
+	}
+
+	position, err := strconv.Atoi(os.Args[3])
+	if err != nil || position < 1 || position > len(config.Lights) {
+		out.fail(exitUsage, "Position must be between 1 and %d", len(config.Lights))
+	}
+
+	config.moveLight(light, position)
+	saveConfig(config)
+	out.say("✓ Moved %s to position %d", light.Name, position)
+	cliList(config)
+}
+
+func cliReorder(config *Config) {
+	if len(os.Args) < 3 {
+		out.fail(exitUsage, "Usage: keylight reorder <light> [<light>...]")
+	}
+
+	// Resolve every light before moving any, since indexes shift as we go
+	lights := make([]*LightRecord, 0, len(os.Args)-2)
+	for _, id := range os.Args[2:] {
+		light, err := config.findLight(id)
+		if err != nil {
+			out.fail(lookupFailure(err), "✗ %v", err)
+		}
+		lights = append(lights, light)
+	}
+
+	for i, light := range lights {
+		config.moveLight(light, i+1)
+	}
+	saveConfig(config)
+	cliList(config)
+}
+
+func cliToggleGroup(config *Config, name string, lights []*LightRecord, fade fadeOptions) {
+	// Keep the group in sync: if any light is on, turn them all off
+	anyOn := false
+	for _, r := range cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		state, err := light.State(ctx)
+		r.State = &state
+		return err
+	}) {
+		if r.OK && r.State.On {
+			anyOn = true
+		}
+	}
+
+	if anyOn {
+		cliTurnOff(config, lights, fade)
+	} else {
+		cliTurnOn(config, lights, fade)
+	}
+}
+
+// groupResultFor describes a group for the structured formats
+func groupResultFor(config *Config, name string) groupResult {
+	lights, _ := config.groupLights(name)
+	names := make([]string, len(lights))
+	for i, light := range lights {
+		names[i] = light.Name
+	}
+	return groupResult{Name: name, Lights: names}
+}
+
+func cliGroup(config *Config, args []string) {
+	const usage = `Usage:
+  keylight group list
+  keylight group create <name> <light> [<light>...]
+  keylight group add <name> <light> [<light>...]
+  keylight group remove <name> <light> [<light>...]
+  keylight group delete <name>`
+
+	if len(args) == 0 || args[0] == "list" {
+		if len(config.Groups) == 0 {
+			out.say("No groups configured. Create one with: keylight group create <name> <light>...")
+			return
+		}
+		out.say("Groups:")
+		for _, name := range config.groupNames() {
+			group := groupResultFor(config, name)
+			out.result.Groups = append(out.result.Groups, group)
+			out.say("  @%s: %s", name, strings.Join(group.Lights, ", "))
+		}
 		return
 	}
 
+	if len(args) < 2 {
+		out.fail(exitUsage, usage)
+	}
+	action, name := args[0], strings.TrimPrefix(args[1], "@")
+	if name == "" {
+		out.fail(exitUsage, "Group name cannot be empty")
+	}
+
+	// Resolve the lights named on the command line
+	var named []*LightRecord
+	for _, id := range args[2:] {
+		light, err := config.findLight(id)
+		if err != nil {
+			out.fail(lookupFailure(err), "✗ %v", err)
+		}
+		named = append(named, light)
+	}
+
+	current, exists := config.groupLights(name)
+	switch action {
+	case "create":
+		if exists {
+			out.fail(exitFailed, "✗ Group '%s' already exists", name)
+		}
+		if len(named) == 0 {
+			out.fail(exitUsage, usage)
+		}
+		config.setGroup(name, named)
+		out.say("✓ Created @%s with %d light(s)", name, len(named))
+	case "add", "remove":
+		if !exists {
+			out.fail(exitNotFound, "✗ Group '%s' not found", name)
+		}
+		if len(named) == 0 {
+			out.fail(exitUsage, usage)
+		}
+		if action == "add" {
+			config.setGroup(name, append(current, named...))
+		} else {
+			removed := make(map[*LightRecord]bool, len(named))
+			for _, light := range named {
+				removed[light] = true
+			}
+			var kept []*LightRecord
+			for _, light := range current {
+				if !removed[light] {
+					kept = append(kept, light)
+				}
+			}
+			config.setGroup(name, kept)
+		}
+		out.say("✓ Updated @%s", name)
+	case "delete":
+		if !exists {
+			out.fail(exitNotFound, "✗ Group '%s' not found", name)
+		}
+		delete(config.Groups, name)
+		out.say("✓ Deleted @%s", name)
+	default:
+		out.fail(exitUsage, usage)
+	}
+	saveConfig(config)
+
+	if _, ok := config.Groups[name]; ok {
+		out.result.Groups = append(out.result.Groups, groupResultFor(config, name))
+	}
+}
+
+func cliScene(config *Config, args []string, fade fadeOptions) {
+	const usage = `Usage:
+  keylight scene list
+  keylight scene save <name> [<light>...]
+  keylight scene apply <name>
+  keylight scene delete <name>`
+
+	ctx := context.Background()
+	if len(args) == 0 || args[0] == "list" {
+		if len(config.Scenes) == 0 {
+			out.say("No scenes saved. Save one with: keylight scene save <name>")
+			return
+		}
+		out.say("Scenes:")
+		for _, name := range config.sceneNames() {
+			entry := sceneEntry{Name: name}
+			out.say("  %s:", name)
+			for _, light := range config.sceneLights(config.Scenes[name]) {
+				state := config.Scenes[name][config.keyOf(light)]
+				r := newLightResult(light)
+				r.State = &state
+				entry.Lights = append(entry.Lights, r)
+
+				status := "Off"
+				if state.On {
+					status = "On"
+				}
+				out.say("    %s: %s | Brightness: %d%% | Temperature: %dK", light.Name, status, state.Brightness, state.Temperature)
+			}
+			out.result.Scenes = append(out.result.Scenes, entry)
+		}
+		return
+	}
+
+	if len(args) < 2 || args[1] == "" {
+		out.fail(exitUsage, usage)
+	}
+	action, name := args[0], args[1]
+
+	switch action {
+	case "save":
+		// Capture every light unless some are named
+		lights := config.orderedLights()
+		if len(args) > 2 {
+			lights = nil
+			for _, id := range args[2:] {
+				light, err := config.findLight(id)
+				if err != nil {
+					out.fail(lookupFailure(err), "✗ %v", err)
+				}
+				lights = append(lights, light)
+			}
+		}
+
+		start := time.Now()
+		scene, results := captureScene(ctx, config, lights, config.lightHandles(lights, printMoved))
+		for _, sr := range results {
+			r := newLightResult(sr.light)
+			r.done(start, sr.err)
+			if sr.err != nil {
+				out.light(r, "✗ Could not read %s, left out of the scene", r.Name)
+			} else {
+				r.State = &sr.state
+				out.add(r)
+			}
+		}
+		if len(scene) == 0 {
+			out.fail(exitFailed, "✗ Could not read any lights")
+		}
+		if config.Scenes == nil {
+			config.Scenes = make(map[string]Scene)
+		}
+		config.Scenes[name] = scene
+		saveConfig(config)
+		out.say("✓ Saved scene %s with %d light(s)", name, len(scene))
+	case "apply":
+		scene, ok := config.Scenes[name]
+		if !ok {
+			out.fail(exitNotFound, "✗ Scene '%s' not found. Use 'keylight scene list' to see saved scenes.", name)
+		}
+		if fade.Duration > 0 {
+			cliFade(config, config.sceneLights(scene), fade, func(light *LightRecord, _ keylight.State) keylight.State {
+				return scene[config.keyOf(light)]
+			}, sceneStateText)
+			return
+		}
+		start := time.Now()
+		changes := config.sceneChanges(scene, config.lightHandles(config.sceneLights(scene), printMoved))
+		for _, sr := range applyScene(ctx, changes) {
+			r := newLightResult(sr.light)
+			r.done(start, sr.err)
+			if sr.err != nil {
+				out.light(r, "✗ Failed to set %s", r.Name)
+			} else {
+				r.State = &sr.state
+				out.light(r, "✓ %s", sceneStateText(r.Name, sr.state))
+			}
+		}
+	case "delete":
+		if _, ok := config.Scenes[name]; !ok {
+			out.fail(exitNotFound, "✗ Scene '%s' not found", name)
+		}
+		delete(config.Scenes, name)
+		saveConfig(config)
+		out.say("✓ Deleted scene %s", name)
+	default:
+		out.fail(exitUsage, usage)
+	}
+}
+
+// sceneStateText describes the state a scene set a light to
+func sceneStateText(name string, state keylight.State) string {
+	if !state.On {
+		return fmt.Sprintf("%s: Off", name)
+	}
+	return fmt.Sprintf("%s: On | Brightness: %d%% | Temperature: %dK", name, state.Brightness, state.Temperature)
+}
+
+func cliDetect() {
+	const usage = "Usage: keylight detect [--replace] [--timeout 5s] [--interface eth0]... [--scan 192.168.1.0/24]"
+
 	config := loadConfig()
-	config.Lights = discovered
+	opts := config.discoveryOptions(2 * time.Second)
+	replace := false
+	explicitIfaces := false
+	scan := ""
+
+	flags, rest, err := cutOptions(os.Args[2:], []string{"--timeout", "--interface", "--scan"}, []string{"--replace"})
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("unknown option: %s", rest[0])
+	}
+	if err != nil {
+		out.fail(exitUsage, "%v\n%s", err, usage)
+	}
+	for _, flag := range flags {
+		switch flag.name {
+		case "--replace":
+			replace = true
+		case "--timeout":
+			timeout, err := time.ParseDuration(flag.value)
+			if err != nil || timeout <= 0 {
+				out.fail(exitUsage, "Invalid timeout: %s", flag.value)
+			}
+			opts.Timeout = timeout
+		case "--interface":
+			// The first --interface replaces the configured list
+			if !explicitIfaces {
+				opts.Interfaces = nil
+				explicitIfaces = true
+			}
+			opts.Interfaces = append(opts.Interfaces, flag.value)
+		case "--scan":
+			scan = flag.value
+		}
+	}
+
+	var discovered []discoveredLight
+	if scan != "" {
+		// Probe the subnet directly for networks that filter multicast
+		out.say("Scanning %s...", scan)
+		found, err := scanSubnet(scan, func(d discoveredLight) {
+			out.say("Found: %s at %s", d.Name(), d.IP)
+		})
+		if err != nil {
+			out.fail(exitUsage, "Error: %v", err)
+		}
+		discovered = found
+	} else {
+		out.say("Discovering lights...")
+		discovered = runDiscovery(opts)
+	}
+
+	if len(discovered) == 0 {
+		out.fail(exitFailed, "✗ No lights found")
+	}
+
+	var result discoveryResult
+	if replace {
+		result = config.replaceDiscovery(discovered)
+	} else {
+		result = config.mergeDiscovery(discovered)
+	}
+	saveConfig(config)
+
+	out.say("\n✓ Discovered %d light(s)", len(discovered))
+	report := func(lights []*LightRecord, change, format string) {
+		for _, light := range lights {
+			r := newLightResult(light)
+			r.Change, r.Stale = change, light.Stale
+			if format == "" {
+				out.add(r)
+			} else {
+				out.light(r, format, light.Name)
+			}
+		}
+	}
+	report(result.Added, "added", "  + %s (new)")
+	report(result.Updated, "updated", "")
+	report(result.Stale, "stale", "  ⚠ %s did not answer (kept, marked stale)")
+	report(result.Removed, "removed", "  - %s (removed)")
+}
+
+func cliAdd(config *Config) {
+	const usage = "Usage: keylight add <host[:port]> [--name <name>]"
+
+	// One address, and nothing else that is not --name
+	flags, rest, err := cutOptions(os.Args[2:], []string{"--name"}, nil)
+	if err != nil || len(rest) != 1 || strings.HasPrefix(rest[0], "-") {
+		if err != nil {
+			out.fail(exitUsage, "%v\n%s", err, usage)
+		}
+		out.fail(exitUsage, usage)
+	}
+	addr := rest[0]
+	var name string
+	for _, flag := range flags {
+		name = flag.value
+	}
+
+	host, port := addr, keylight.DefaultPort
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			out.fail(exitUsage, "Invalid port: %s", p)
+		}
+		host, port = h, n
+	}
+	host = strings.Trim(host, "[]")
+
+	// Make sure something that looks like a light is listening there
+	d := discoveredLight{IP: host, Port: port}
+	start := time.Now()
+	info, err := client.Light(d.record().Addr()).Info(context.Background())
+	if err != nil {
+		out.fail(exitFailed, "✗ No Elgato light answered at %s: %v", addr, err)
+	}
+	d.Info = info
+
+	light, added := config.upsertLight(d)
+	if name != "" {
+		light.Name = name
+		light.CustomName = true
+	}
+	saveConfig(config)
+
+	r := newLightResult(light)
+	r.done(start, nil)
+	r.Info = &info
+	if added {
+		r.Change = "added"
+		out.light(r, "✓ Added %s (%s, serial %s)", light.Name, info.ProductName, info.SerialNumber)
+	} else {
+		r.Change = "updated"
+		out.light(r, "✓ Updated %s (%s, serial %s)", light.Name, info.ProductName, info.SerialNumber)
+	}
+}
+
+func cliForget(config *Config) {
+	if len(os.Args) < 3 {
+		out.fail(exitUsage, "Usage: keylight forget <light_name|index|serial>")
+	}
+
+	light, err := config.findLight(os.Args[2])
+	if err != nil {
+		out.fail(lookupFailure(err), "✗ %v", err)
+	}
+
+	config.forgetLight(light)
 	saveConfig(config)
-	fmt.Printf("\n✓ Discovered %d light(s)\n", len(discovered))
+
+	r := newLightResult(light)
+	r.Change = "removed"
+	out.light(r, "✓ Forgot %s", light.Name)
 }
 
-func cliStatus(config *Config) {
+func cliStatus(config *Config, lights []*LightRecord) {
 	if len(config.Lights) == 0 {
-		fmt.Println("No lights configured. Run: keylight detect")
+		out.say("No lights configured. Run: keylight detect")
 		return
 	}
 
-	fmt.Println("Light status:")
-	for name, ip := range config.Lights {
-		state, err := getLightState(ip)
-		if err != nil {
-			fmt.Printf("  %s: Offline\n", name)
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		state, err := light.State(ctx)
+		if err == nil {
+			r.State = &state
+		}
+		return err
+	})
+
+	out.say("Light status:")
+	for _, r := range results {
+		if !r.OK {
+			out.light(r, "  %s: Offline", r.Name)
 			continue
 		}
 
 		status := "Off"
-		if state.On == 1 {
+		if r.State.On {
 			status = "On"
 		}
-		temp := int(1000000 / state.Temperature)
-		fmt.Printf("  %s: %s | Brightness: %d%% | Temperature: %dK\n", name, status, state.Brightness, temp)
+		out.light(r, "  %s: %s | Brightness: %d%% | Temperature: %dK", r.Name, status, r.State.Brightness, r.State.Temperature)
+	}
+}
+
+func cliInfo(config *Config, lights []*LightRecord) {
+	if len(config.Lights) == 0 {
+		out.say("No lights configured. Run: keylight detect")
+		return
+	}
+
+	results := cliEach(config, lights, func(ctx context.Context, light keylight.Light, r *lightResult) error {
+		info, err := light.Info(ctx)
+		if err == nil {
+			r.Info = &info
+		}
+		return err
+	})
+
+	out.say("Light info:")
+	for _, r := range results {
+		if !r.OK {
+			out.light(r, "  %s: Offline", r.Name)
+			continue
+		}
+		out.light(r, "%s", infoText("  ", r.Name, *r.Info))
 	}
 }
 
+// infoText formats a light's accessory info as an indented block
+func infoText(indent, name string, info keylight.AccessoryInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s:\n", indent, name)
+	fmt.Fprintf(&b, "%s  Product:      %s\n", indent, info.ProductName)
+	fmt.Fprintf(&b, "%s  Display name: %s\n", indent, info.DisplayName)
+	fmt.Fprintf(&b, "%s  Serial:       %s\n", indent, info.SerialNumber)
+	fmt.Fprintf(&b, "%s  Firmware:     %s (build %d)\n", indent, info.FirmwareVersion, info.FirmwareBuildNumber)
+	fmt.Fprintf(&b, "%s  Board type:   %d", indent, info.HardwareBoardType)
+	return b.String()
+}
+
 func cliHelp() {
 	help := `Elgato Key Light Controller
 
@@ -1277,15 +2340,59 @@ COMMANDS:
   temp <value>                Set temperature to specific value (2900-7000)
 
   list                        Show all configured lights
-  detect                      Discover lights on network
+  detect                      Discover lights and merge them into the config
+  detect --replace            Discover lights and drop any that did not answer
+  detect --timeout 5s         Browse for longer than the default 2s
+  detect --interface eth0     Only browse on the given interface (repeatable)
+  detect --scan <cidr>        Probe a subnet instead of using mDNS
+  add <host[:port]>           Add a light by address (verifies it first)
+      [--name <name>]
+  forget <light>              Remove a light from the config
+  move <light> <position>     Change a light's index
+  reorder <light> [<light>..] Put the given lights first, in that order
   status                      Show status of all lights
+  info                        Show product, serial and firmware of all lights
+
+  group list                  Show all groups
+  group create <name> <light>...  Create a group of lights
+  group add <name> <light>... Add lights to a group
+  group remove <name> <light>...  Remove lights from a group
+  group delete <name>         Delete a group
+  @<group>                    Toggle a group (all off if any is on)
+  @<group> <command>          Run on, off, bright, temp, status or info on a group
+
+  scene list                  Show saved scenes
+  scene save <name> [<light>...]  Save the current state of all (or the given) lights
+  scene apply <name>          Restore a saved scene
+  scene delete <name>         Delete a scene
+
+  serve                       Serve a REST API for other tools (see /openapi.yaml)
+      [--listen 127.0.0.1:8080] [--poll 5s] [--discover 1m|0]
+  daemon [--poll 5s]          Keep lights' state and connections warm; other commands
+                              go through it while it runs
+  mqtt [--broker <url>]       Bridge lights to MQTT with Home Assistant discovery
+      [--prefix keylight] [--discovery-prefix homeassistant|none]
+      [--username <user>] [--password <password>] [--poll 5s]
 
   <light_name|index>          Toggle specific light
   <light_name> <command>      Control specific light
-                              Commands: on, off, bright [+|-|value], temp [+|-|value]
+                              Commands: on, off, bright [+|-|value], temp [+|-|value], status, info
 
   help                        Show this help message
 
+OPTIONS:
+  --json                      Print the result as JSON (same as --output json)
+  --output <format>, -o       text (default), json, yaml or table
+  --fade <duration>           Fade on, off, bright, temp and scene apply (e.g. 2s)
+  --fade-rate <n>             Fade steps per second (default 20, max 50)
+  --fade-curve <curve>        linear, ease-in, ease-out or ease-in-out (default)
+
+EXIT CODES:
+  0  Every light succeeded      3  Some lights failed
+  1  Every light failed         4  No lights configured
+  2  Bad usage                  5  Light, group or scene not found
+  130  Fade cancelled with Ctrl-C
+
 EXAMPLES:
   keylight on                 Turn on all lights
   keylight bright 50          Set all lights to 50% brightness
@@ -1294,201 +2401,62 @@ EXAMPLES:
   keylight 1                  Toggle light 1
   keylight 2 bright +         Increase light 2 brightness
   keylight "My Light" on      Turn on specific light
+  keylight @desk bright 60    Set the desk group to 60% brightness
+  keylight scene apply video  Restore the lights saved as "video"
+  keylight off --fade 2s      Dim all lights down and turn them off over 2s
+  keylight serve --listen :8080  Serve the REST API on every interface
   keylight status             Check status of all lights
 `
 	fmt.Println(help)
 }
 
-func cliSpecificLight(config *Config, lightIdentifier string) {
-	// Try to find light by name or index
-	var targetIP string
-	var targetName string
-
-	// Check if it's a numeric index
-	var index int
-	n, _ := fmt.Sscanf(lightIdentifier, "%d", &index)
-	if n == 1 && index > 0 {
-		// Find light by index
-		i := 1
-		for name, ip := range config.Lights {
-			if i == index {
-				targetIP = ip
-				targetName = name
-				break
-			}
-			i++
-		}
-	} else {
-		// Find light by name
-		for name, ip := range config.Lights {
-			if name == lightIdentifier {
-				targetIP = ip
-				targetName = name
-				break
-			}
-		}
-	}
-
-	if targetIP == "" {
-		fmt.Printf("✗ Light '%s' not found. Use 'keylight list' to see available lights.\n", lightIdentifier)
-		os.Exit(1)
+func cliSpecificLight(config *Config, lightIdentifier string, fade fadeOptions) {
+	// Try to find light by index, serial number or name
+	target, err := config.findLight(lightIdentifier)
+	if err != nil {
+		out.fail(lookupFailure(err), "✗ %v", err)
 	}
+	targets := []*LightRecord{target}
 
 	// If no command specified, toggle the light (fast mode)
 	if len(os.Args) < 3 {
-		if err := toggleLightFast(targetIP); err != nil {
-			fmt.Printf("✗ Failed to toggle %s: %v\n", targetName, err)
-			os.Exit(1)
-		} else {
-			fmt.Printf("✓ Toggled %s\n", targetName)
+		ctx := context.Background()
+		handle := config.lightHandle(target, printMoved)
+		r := newLightResult(target)
+		start := time.Now()
+		err := toggleLightFast(ctx, handle)
+		r.done(start, err)
+		if err != nil {
+			out.light(r, "✗ Failed to toggle %s", r.Name)
+			return
 		}
+		r.State = out.stateAfter(ctx, handle)
+		out.light(r, "✓ Toggled %s", r.Name)
 		return
 	}
 
-	command := os.Args[2]
-
+	// The group commands, on just this light
+	command, args := os.Args[2], os.Args[3:]
 	switch command {
 	case "on":
-		onState := 1
-		if err := setLight(targetIP, &onState, nil, nil); err != nil {
-			fmt.Printf("✗ Failed to turn on %s\n", targetName)
-		} else {
-			fmt.Printf("✓ Turned on %s\n", targetName)
-		}
+		cliTurnOn(config, targets, fade)
 	case "off":
-		offState := 0
-		if err := setLight(targetIP, &offState, nil, nil); err != nil {
-			fmt.Printf("✗ Failed to turn off %s\n", targetName)
-		} else {
-			fmt.Printf("✓ Turned off %s\n", targetName)
-		}
+		cliTurnOff(config, targets, fade)
 	case "bright":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: keylight <light> bright [+|-|value]")
-			os.Exit(1)
-		}
-		action := os.Args[3]
-		switch action {
-		case "+":
-			state, err := getLightState(targetIP)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", targetName)
-				os.Exit(1)
-			}
-			newBright := state.Brightness + 5
-			if newBright > 100 {
-				newBright = 100
-			}
-			if err := setLight(targetIP, nil, &newBright, nil); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", targetName)
-			} else {
-				fmt.Printf("✓ %s brightness: %d%%\n", targetName, newBright)
-			}
-		case "-":
-			state, err := getLightState(targetIP)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", targetName)
-				os.Exit(1)
-			}
-			newBright := state.Brightness - 5
-			if newBright < 3 {
-				newBright = 3
-			}
-			if err := setLight(targetIP, nil, &newBright, nil); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", targetName)
-			} else {
-				fmt.Printf("✓ %s brightness: %d%%\n", targetName, newBright)
-			}
-		default:
-			var brightness int
-			n, err := fmt.Sscanf(action, "%d", &brightness)
-			if n == 1 && err == nil {
-				if brightness < 3 || brightness > 100 {
-					fmt.Println("Brightness must be between 3 and 100")
-					os.Exit(1)
-				}
-				if err := setLight(targetIP, nil, &brightness, nil); err != nil {
-					fmt.Printf("✗ Failed to set brightness for %s\n", targetName)
-				} else {
-					fmt.Printf("✓ %s brightness: %d%%\n", targetName, brightness)
-				}
-			} else {
-				fmt.Println("Invalid brightness value")
-				os.Exit(1)
-			}
+		if len(args) < 1 {
+			out.fail(exitUsage, "Usage: keylight <light> bright [+|-|value]")
 		}
+		cliBrightness(config, targets, args, fade)
 	case "temp":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: keylight <light> temp [+|-|value]")
-			os.Exit(1)
-		}
-		action := os.Args[3]
-		switch action {
-		case "+":
-			state, err := getLightState(targetIP)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", targetName)
-				os.Exit(1)
-			}
-			currentTemp := int(1000000 / state.Temperature)
-			newTemp := currentTemp + 200
-			if newTemp > 7000 {
-				newTemp = 7000
-			}
-			if err := setLight(targetIP, nil, nil, &newTemp); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", targetName)
-			} else {
-				fmt.Printf("✓ %s temperature: %dK\n", targetName, newTemp)
-			}
-		case "-":
-			state, err := getLightState(targetIP)
-			if err != nil {
-				fmt.Printf("✗ Failed to get state for %s\n", targetName)
-				os.Exit(1)
-			}
-			currentTemp := int(1000000 / state.Temperature)
-			newTemp := currentTemp - 200
-			if newTemp < 2900 {
-				newTemp = 2900
-			}
-			if err := setLight(targetIP, nil, nil, &newTemp); err != nil {
-				fmt.Printf("✗ Failed to adjust %s\n", targetName)
-			} else {
-				fmt.Printf("✓ %s temperature: %dK\n", targetName, newTemp)
-			}
-		default:
-			var temperature int
-			n, err := fmt.Sscanf(action, "%d", &temperature)
-			if n == 1 && err == nil {
-				if temperature < 2900 || temperature > 7000 {
-					fmt.Println("Temperature must be between 2900K and 7000K")
-					os.Exit(1)
-				}
-				if err := setLight(targetIP, nil, nil, &temperature); err != nil {
-					fmt.Printf("✗ Failed to set temperature for %s\n", targetName)
-				} else {
-					fmt.Printf("✓ %s temperature: %dK\n", targetName, temperature)
-				}
-			} else {
-				fmt.Println("Invalid temperature value")
-				os.Exit(1)
-			}
+		if len(args) < 1 {
+			out.fail(exitUsage, "Usage: keylight <light> temp [+|-|value]")
 		}
+		cliTemperature(config, targets, args, fade)
+	case "info":
+		cliInfo(config, targets)
 	case "status":
-		state, err := getLightState(targetIP)
-		if err != nil {
-			fmt.Printf("✗ %s: Offline\n", targetName)
-		} else {
-			status := "Off"
-			if state.On == 1 {
-				status = "On"
-			}
-			temp := int(1000000 / state.Temperature)
-			fmt.Printf("%s: %s | Brightness: %d%% | Temperature: %dK\n", targetName, status, state.Brightness, temp)
-		}
+		cliStatus(config, targets)
 	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Available commands: on, off, bright, temp, status")
-		os.Exit(1)
+		out.fail(exitUsage, "Unknown command: %s\nAvailable commands: on, off, bright, temp, status, info", command)
 	}
 }
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"elgato-keylight/keylight"
+)
+
+// Handles report moves to Update, which records and saves them
+func TestLightMoved(t *testing.T) {
+	record := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5"}
+	m := model{config: testConfig(t, record)}
+
+	m.lightMoved(lightMovedMsg{light: record, ip: "10.0.0.9"})
+	if record.IP != "10.0.0.9" {
+		t.Errorf("record = %+v", record)
+	}
+	if saved := loadConfig().Lights["BW001"]; saved == nil || saved.IP != "10.0.0.9" {
+		t.Errorf("saved %+v", saved)
+	}
+
+	// A light removed meanwhile is not brought back
+	gone := &LightRecord{Serial: "BW002", Name: "Gone", IP: "10.0.0.6"}
+	m.lightMoved(lightMovedMsg{light: gone, ip: "10.0.0.7"})
+	if gone.IP != "10.0.0.6" || loadConfig().Lights["BW002"] != nil {
+		t.Error("moved a light that is not configured")
+	}
+}
+
+// The TUI's commands run at the same time and share its handles, so a light
+// that moved is looked up and reported once
+func TestCommandsShareHandles(t *testing.T) {
+	_, desk := newFakeLight(t, "BW001", "Desk")
+	_, shelf := newFakeLight(t, "BW002", "Shelf")
+	shelf.Hostname, shelf.IP = shelf.IP, "127.0.0.2" // moved
+	m := model{config: testConfig(t, desk, shelf), moves: make(chan lightMovedMsg)}
+	m.config.relocate = func(light *LightRecord, ip string, port int) {
+		go func() { m.moves <- lightMovedMsg{light: light, ip: ip, port: port} }()
+	}
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+
+	var cmds []tea.Cmd
+	for i := 0; i < 4; i++ {
+		cmds = append(cmds, fetchStates(m.lights), fetchInfo(m.lights), toggleLights(m.getSelectedLights()))
+		m.brightnessValue = 20 + i
+		for _, control := range []controlFocus{focusBrightness, focusTurnOn} {
+			m.focusedControl = control
+			_, cmd := m.activateControl()
+			cmds = append(cmds, cmd)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, cmd := range cmds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd()
+		}()
+	}
+	wg.Wait()
+
+	msg := <-m.moves
+	if msg.light != shelf || msg.ip != shelf.Hostname {
+		t.Errorf("moved %+v, want Shelf to %s", msg, shelf.Hostname)
+	}
+}
+
+// Controls change the lights in the background and report back with a
+// message; Update shows it, remembers the values set and reads the lights
+// again
+func TestActivateControl(t *testing.T) {
+	desk, record := newFakeLight(t, "BW001", "Desk")
+	m := model{config: testConfig(t, record), states: map[string]lightStatus{}}
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+	m.focusedControl, m.brightnessValue = focusBrightness, 35
+
+	next, cmd := m.activateControl()
+	if desk.current().Brightness != 50 {
+		t.Fatal("light set before the command ran")
+	}
+	msg := cmd()
+	if desk.current().Brightness != 35 {
+		t.Errorf("brightness = %d, want 35", desk.current().Brightness)
+	}
+	done, ok := msg.(controlDoneMsg)
+	if !ok || done.brightness != 35 {
+		t.Fatalf("command returned %#v", msg)
+	}
+
+	next, cmd = next.(model).Update(done)
+	m = next.(model)
+	if m.message != "✓ Brightness set to 35%" || m.config.LastBrightness != 35 || loadConfig().LastBrightness != 35 {
+		t.Errorf("message %q, last brightness %d", m.message, m.config.LastBrightness)
+	}
+	if !m.refreshing || cmd == nil {
+		t.Error("lights not read again after the change")
+	}
+	states, ok := cmd().(stateMsg)
+	if !ok || states["BW001"].state.Brightness != 35 {
+		t.Errorf("refresh returned %#v", states)
+	}
+}
+
+// A light that stops answering keeps its last known state, marked as failing
+func TestStateMsgKeepsLastState(t *testing.T) {
+	m := model{states: map[string]lightStatus{}, refreshing: true}
+	read := time.Now()
+	next, _ := m.Update(stateMsg{"BW001": {state: keylight.State{On: true, Brightness: 40}, updated: read}})
+	next, _ = next.(model).Update(stateMsg{"BW001": {err: errors.New("timeout")}})
+	st := next.(model).states["BW001"]
+	if st.err == nil || !st.state.On || st.state.Brightness != 40 || !st.updated.Equal(read) {
+		t.Errorf("state = %+v", st)
+	}
+}
+
+// Discovery streams lights into the view as they answer and are identified,
+// then merges them into the config and controls them
+func TestDiscoveryMessages(t *testing.T) {
+	desk := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5"}
+	m := model{config: testConfig(t, desk), states: map[string]lightStatus{}}
+	m.lights = m.config.lightHandles(m.config.orderedLights(), nil)
+	m.lightsList = m.config.orderedLights()
+	events := make(chan tea.Msg)
+	close(events)
+	m.discovering, m.discoveryEvents = true, events
+
+	if m.startDiscovery() != nil {
+		t.Error("started a second discovery")
+	}
+	if msg := waitForDiscovery(events)(); msg != nil {
+		t.Errorf("waitForDiscovery() on a finished discovery = %#v", msg)
+	}
+
+	found := discoveredLight{Instance: "Elgato Key Light 1A2B", IP: "10.0.0.6", Port: 9123}
+	next, _ := m.Update(lightFoundMsg(found))
+	m = next.(model)
+	if len(m.discovered) != 1 || m.discovered[0].identified || !strings.Contains(m.renderDiscovery(), "identifying") {
+		t.Fatalf("discovered = %+v", m.discovered)
+	}
+
+	found.Info = keylight.AccessoryInfo{ProductName: "Elgato Key Light", SerialNumber: "BW002"}
+	next, _ = m.Update(lightIdentifiedMsg(found))
+	m = next.(model)
+	if !m.discovered[0].identified || !strings.Contains(m.renderDiscovery(), "BW002") {
+		t.Fatalf("discovered = %+v", m.discovered)
+	}
+
+	next, cmd := m.Update(discoveryDoneMsg{found: []discoveredLight{found}})
+	m = next.(model)
+	if m.discovering || m.discovered != nil || cmd == nil {
+		t.Error("discovery not finished")
+	}
+	if m.message != "✓ Discovered 1 light(s), 1 new" {
+		t.Errorf("message = %q", m.message)
+	}
+	if len(m.lightsList) != 2 || m.lightsList[0] != desk || m.lightsList[1].Serial != "BW002" {
+		t.Errorf("lightsList = %+v", m.lightsList)
+	}
+	if _, ok := m.lights["BW002"]; !ok || len(m.lights) != 2 {
+		t.Errorf("no handle for the new light: %v", m.lights)
+	}
+	if loadConfig().Lights["BW002"] == nil {
+		t.Error("new light not saved")
+	}
+
+	m.discovering = true
+	next, _ = m.Update(discoveryDoneMsg{err: errors.New("no multicast")})
+	if msg := next.(model).message; msg != "✗ Failed to discover: no multicast" {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+// key is a key press as Update receives it
+func key(s string) tea.KeyMsg {
+	switch s {
+	case "up":
+		return tea.KeyMsg{Type: tea.KeyUp}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	case "enter":
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	case " ":
+		return tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+// press sends keys to the model in turn
+func press(t *testing.T, m model, keys ...string) model {
+	t.Helper()
+	for _, k := range keys {
+		next, _ := m.Update(key(k))
+		m = next.(model)
+	}
+	return m
+}
+
+// selectionModel is a TUI with three lights, two of which share a name, and
+// a group
+func selectionModel(t *testing.T) model {
+	t.Helper()
+	config := testConfig(t,
+		&LightRecord{Serial: "BW001", Name: "Elgato Key Light", IP: "10.0.0.5"},
+		&LightRecord{Serial: "BW002", Name: "Elgato Key Light", IP: "10.0.0.6"},
+		&LightRecord{Serial: "BW003", Name: "Shelf", IP: "10.0.0.7"},
+	)
+	config.Order = []string{"BW001", "BW002", "BW003"}
+	config.Groups = map[string][]string{"desk": {"BW001", "BW003"}}
+	return model{
+		config:     config,
+		lights:     config.lightHandles(config.orderedLights(), nil),
+		lightsList: config.orderedLights(),
+		groupsList: config.groupNames(),
+		states:     map[string]lightStatus{},
+	}
+}
+
+// selected returns the serials of the lights the controls would change
+func selected(m model) []string {
+	var serials []string
+	for _, light := range m.getSelectedLights() {
+		serials = append(serials, light.(*resolvingLight).record.Serial)
+	}
+	return serials
+}
+
+func TestSelectLights(t *testing.T) {
+	m := selectionModel(t)
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW002", "BW003"}) {
+		t.Errorf("all lights: selected %v", got)
+	}
+
+	// Number keys select one light, even one that shares its name
+	m = press(t, m, "2")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW002"}) || m.listCursor != 2 {
+		t.Errorf("after 2: selected %v, cursor %d", got, m.listCursor)
+	}
+	m = press(t, m, "9")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW002"}) {
+		t.Errorf("after 9 with three lights: selected %v", got)
+	}
+
+	// Space in the list adds lights to the selection and takes them out
+	m.focusedControl = focusLightList
+	m = press(t, m, "up", " ", "down", "down", " ")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW002", "BW003"}) {
+		t.Errorf("after adding 1 and 3: selected %v", got)
+	}
+	if !strings.Contains(m.renderControlsBox(), "TURN ON 3 Lights") {
+		t.Errorf("controls do not show the selection:\n%s", m.renderControlsBox())
+	}
+	m = press(t, m, "up", " ")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW003"}) {
+		t.Errorf("after taking 2 out: selected %v", got)
+	}
+	m = press(t, m, "up", " ", "down", "down", " ")
+	if got := selected(m); m.selectedLightMode != allLights || len(got) != 3 {
+		t.Errorf("emptying the selection: mode %v, selected %v", m.selectedLightMode, got)
+	}
+
+	// Enter selects the row: a single light, a group, or All
+	m = press(t, m, "down", "enter")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW003"}) || m.message != "✓ Controlling @desk" {
+		t.Errorf("group row: selected %v, message %q", got, m.message)
+	}
+	m = press(t, m, "up", "enter")
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW003"}) {
+		t.Errorf("light row: selected %v", got)
+	}
+	m.listCursor = 0
+	m = press(t, m, "enter")
+	if m.selectedLightMode != allLights {
+		t.Errorf("All row: mode %v", m.selectedLightMode)
+	}
+}
+
+// The cursor stays within the list; moving down past the last row leaves
+// the list for the buttons, and up from the buttons comes back
+func TestLightListCursor(t *testing.T) {
+	m := selectionModel(t)
+	m.focusedControl = focusLightList
+	m = press(t, m, "up", "up")
+	if m.listCursor != 0 || m.focusedControl != focusLightList {
+		t.Errorf("cursor %d, focus %v", m.listCursor, m.focusedControl)
+	}
+	m = press(t, m, "down", "down", "down", "down")
+	if m.listCursor != 4 {
+		t.Errorf("cursor on the group row = %d, want 4", m.listCursor)
+	}
+	m = press(t, m, "down")
+	if m.focusedControl != focusToggle {
+		t.Errorf("focus after the last row = %v, want the toggle button", m.focusedControl)
+	}
+	m = press(t, m, "up")
+	if m.focusedControl != focusLightList || m.listCursor != 4 {
+		t.Errorf("back up: focus %v, cursor %d", m.focusedControl, m.listCursor)
+	}
+}
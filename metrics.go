@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Request metrics, recorded for every request made to a light. Only the
+// server modes export them, at /metrics.
+var (
+	lightRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keylight_requests_total",
+		Help: "Requests made to lights, by operation and result (ok or failed).",
+	}, []string{"light", "serial", "op", "result"})
+
+	lightRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keylight_request_retries_total",
+		Help: "Requests retried at a light's new address after it stopped answering.",
+	}, []string{"light", "serial", "op"})
+
+	lightRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keylight_request_duration_seconds",
+		Help:    "How long requests to lights took, failed ones included.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2},
+	}, []string{"light", "serial"})
+)
+
+// observeRequest records a request to a light that started at start
+func observeRequest(light *LightRecord, op string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	lightRequests.WithLabelValues(light.Name, light.Serial, op, result).Inc()
+	lightRequestDuration.WithLabelValues(light.Name, light.Serial).Observe(time.Since(start).Seconds())
+}
+
+// stateCollector exports the server's cached light states. They are read
+// when Prometheus scrapes, so lights removed from the config disappear.
+// Lights are labelled with their config key as well as their name and
+// serial, so lights that share a name stay apart.
+type stateCollector struct {
+	s *server
+}
+
+var (
+	lightUpDesc = prometheus.NewDesc("keylight_light_up",
+		"Whether the light answered the last poll.", []string{"key", "light", "serial"}, nil)
+	lightOnDesc = prometheus.NewDesc("keylight_light_on",
+		"Whether the light is on.", []string{"key", "light", "serial"}, nil)
+	lightBrightnessDesc = prometheus.NewDesc("keylight_light_brightness_percent",
+		"The light's brightness.", []string{"key", "light", "serial"}, nil)
+	lightTemperatureDesc = prometheus.NewDesc("keylight_light_temperature_kelvin",
+		"The light's color temperature.", []string{"key", "light", "serial"}, nil)
+)
+
+func (c stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lightUpDesc
+	ch <- lightOnDesc
+	ch <- lightBrightnessDesc
+	ch <- lightTemperatureDesc
+}
+
+func (c stateCollector) Collect(ch chan<- prometheus.Metric) {
+	config := c.s.currentConfig()
+	for _, key := range config.Order {
+		light := config.Lights[key]
+		if light == nil {
+			continue
+		}
+		cached, known := c.s.cached(light)
+		up := 0.0
+		if known && cached.err == nil {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(lightUpDesc, prometheus.GaugeValue, up, key, light.Name, light.Serial)
+		// The last known state stays exported while a light is offline
+		if !known {
+			continue
+		}
+		on := 0.0
+		if cached.state.On {
+			on = 1
+		}
+		ch <- prometheus.MustNewConstMetric(lightOnDesc, prometheus.GaugeValue, on, key, light.Name, light.Serial)
+		ch <- prometheus.MustNewConstMetric(lightBrightnessDesc, prometheus.GaugeValue, float64(cached.state.Brightness), key, light.Name, light.Serial)
+		ch <- prometheus.MustNewConstMetric(lightTemperatureDesc, prometheus.GaugeValue, float64(cached.state.Temperature), key, light.Name, light.Serial)
+	}
+}
+
+// metricsHandler serves the request metrics, the server's light states and
+// the usual Go process metrics
+func (s *server) metricsHandler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		lightRequests,
+		lightRetries,
+		lightRequestDuration,
+		stateCollector{s: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
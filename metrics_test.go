@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// scrape returns the metrics a server serves
+func scrape(t *testing.T, s *server) string {
+	t.Helper()
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// Lights that share a name are told apart by their key; lights never read
+// only report that they are down
+func TestStateMetrics(t *testing.T) {
+	left := &LightRecord{Serial: "BW001", Name: "Elgato Key Light", IP: "10.0.0.5"}
+	right := &LightRecord{Serial: "BW002", Name: "Elgato Key Light", IP: "10.0.0.6"}
+	legacy := &LightRecord{Name: "Shelf", IP: "10.0.0.7"}
+	config := testConfig(t, left, right, legacy)
+	config.Order = []string{"BW002", "BW001", "Shelf"}
+	s := newServer(config)
+	s.store(left, keylight.State{On: true, Brightness: 40, Temperature: 4000}, nil)
+	s.store(right, keylight.State{Brightness: 70, Temperature: 5000}, nil)
+	s.store(right, keylight.State{}, errors.New("timeout"))
+
+	body := scrape(t, s)
+	for _, want := range []string{
+		`keylight_light_up{key="BW001",light="Elgato Key Light",serial="BW001"} 1`,
+		`keylight_light_on{key="BW001",light="Elgato Key Light",serial="BW001"} 1`,
+		`keylight_light_brightness_percent{key="BW001",light="Elgato Key Light",serial="BW001"} 40`,
+		`keylight_light_up{key="BW002",light="Elgato Key Light",serial="BW002"} 0`,
+		`keylight_light_on{key="BW002",light="Elgato Key Light",serial="BW002"} 0`,
+		`keylight_light_temperature_kelvin{key="BW002",light="Elgato Key Light",serial="BW002"} 5000`,
+		`keylight_light_up{key="Shelf",light="Shelf",serial=""} 0`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+	if strings.Contains(body, `keylight_light_on{key="Shelf"`) {
+		t.Error("state exported for a light never read")
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	record := &LightRecord{Serial: "BW901", Name: "Metrics"}
+	observeRequest(record, "set", time.Now(), nil)
+	observeRequest(record, "set", time.Now(), nil)
+	observeRequest(record, "set", time.Now(), errors.New("timeout"))
+
+	body := scrape(t, newServer(testConfig(t)))
+	for _, want := range []string{
+		`keylight_requests_total{light="Metrics",op="set",result="ok",serial="BW901"} 2`,
+		`keylight_requests_total{light="Metrics",op="set",result="failed",serial="BW901"} 1`,
+		`keylight_request_duration_seconds_count{light="Metrics",serial="BW901"} 3`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+}
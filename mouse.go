@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Lines taken by each row of the controls box; every row is bordered
+const controlRowHeight = 3
+
+// updateMouse handles clicks, drags and the scroll wheel. Clicking a light
+// list row selects it (Ctrl-click adds it to the selection), clicking a
+// button activates it, clicking or dragging on a slider bar sets its value,
+// and the wheel nudges the focused slider.
+func (m model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if tea.MouseEvent(msg).IsWheel() {
+		return m.wheel(msg)
+	}
+	if msg.Button != tea.MouseButtonLeft && msg.Action != tea.MouseActionRelease {
+		return m, nil
+	}
+
+	// Position inside the box content
+	_, layout := m.renderWithLayout()
+	x := msg.X - boxStyle.GetBorderLeftSize() - boxStyle.GetPaddingLeft()
+	y := msg.Y - boxStyle.GetBorderTopSize() - boxStyle.GetPaddingTop()
+
+	if m.detailView {
+		return m.mouseDetail(msg, x, y-layout.detail)
+	}
+
+	// Dragging a slider keeps going wherever the pointer is
+	if m.dragging {
+		m.setBarValue(x - m.barStart())
+		if msg.Action == tea.MouseActionRelease {
+			m.dragging = false
+			if !m.config.LiveApply {
+				return m.activateControl()
+			}
+		}
+		return m, m.adjusted()
+	}
+	if msg.Action != tea.MouseActionPress {
+		return m, nil
+	}
+
+	if row := y - layout.lights; row >= 0 && row < m.listRows() {
+		m.listCursor = row
+		m.selectRow(row, msg.Ctrl)
+		return m, nil
+	}
+
+	row := y - layout.controls
+	if row < 0 {
+		return m, nil
+	}
+	switch row / controlRowHeight {
+	case 0:
+		// Action buttons, one space apart
+		toggleBtn, turnOffBtn, turnOnBtn := m.renderActionButtons()
+		left := 0
+		for i, btn := range []string{toggleBtn, turnOffBtn, turnOnBtn} {
+			width := lipgloss.Width(btn)
+			if x >= left && x < left+width {
+				m.focusedControl = focusToggle + controlFocus(i)
+				return m.activateControl()
+			}
+			left += width + 1
+		}
+	case 1:
+		return m.clickSlider(focusBrightness, x)
+	case 2:
+		return m.clickSlider(focusTemperature, x)
+	case 3:
+		if len(m.scenesList) > 0 {
+			return m.clickScene(x)
+		}
+	}
+	return m, nil
+}
+
+// wheel nudges the focused slider: up for more, down for less
+func (m model) wheel(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	var key tea.KeyMsg
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		key = tea.KeyMsg{Type: tea.KeyRight}
+	case tea.MouseButtonWheelDown:
+		key = tea.KeyMsg{Type: tea.KeyLeft}
+	default:
+		return m, nil
+	}
+	if m.detailView || m.focusedControl == focusBrightness || m.focusedControl == focusTemperature {
+		return m.Update(key)
+	}
+	return m, nil
+}
+
+// barStart is where the slider bars begin, after the slider buttons
+func (m model) barStart() int {
+	return lipgloss.Width(buttonStyle.Render("   Brightness   ")) + 3
+}
+
+// clickSlider focuses a slider. Clicking its button applies it; pressing on
+// its bar sets the value there and starts a drag.
+func (m model) clickSlider(focus controlFocus, x int) (tea.Model, tea.Cmd) {
+	m.focusedControl = focus
+	bar := x - m.barStart()
+	if bar < 0 {
+		return m.activateControl()
+	}
+	if bar >= barWidth {
+		return m, nil
+	}
+	m.setBarValue(bar)
+	m.dragging = true
+	return m, m.adjusted()
+}
+
+// setBarValue sets the focused slider to the value at a cell of its bar
+func (m *model) setBarValue(cell int) {
+	if m.focusedControl == focusBrightness {
+		m.brightnessValue = barValue(cell, 3, 100, 1)
+	} else {
+		m.temperatureValue = barValue(cell, 2900, 7000, 50)
+	}
+}
+
+// barValue converts a bar cell to a value between lo and hi, rounded to
+// step. The last cell is hi, like a full bar.
+func barValue(cell, lo, hi, step int) int {
+	fraction := max(0, min(float64(cell+1)/barWidth, 1))
+	value := float64(lo) + fraction*float64(hi-lo)
+	return max(lo, min(int(math.Round(value/float64(step)))*step, hi))
+}
+
+// clickScene applies a scene when its name is clicked, or the selected one
+// when the Scene button is
+func (m model) clickScene(x int) (tea.Model, tea.Cmd) {
+	m.focusedControl = focusScene
+	left := m.barStart()
+	for i, name := range m.scenesList {
+		width := lipgloss.Width(name)
+		if i == m.selectedScene {
+			width += lipgloss.Width("▶ ")
+		}
+		if x >= left && x < left+width {
+			m.selectedScene = i
+			return m.activateControl()
+		}
+		left += width + 3
+	}
+	if x < m.barStart() {
+		return m.activateControl()
+	}
+	return m, nil
+}
+
+// mouseDetail handles the mouse in the per-light view, y being the line in
+// the slider list: pressing or dragging on a bar moves that slider
+func (m model) mouseDetail(msg tea.MouseMsg, x, y int) (tea.Model, tea.Cmd) {
+	if msg.Action == tea.MouseActionRelease {
+		return m, nil
+	}
+
+	line := 0
+	for i, light := range m.lightsList {
+		state, ok := m.sliders[recordKey(light)]
+		if !ok {
+			line += 3 // Name, "No state read yet", blank
+			continue
+		}
+		slider := y - line - 1 // the name comes first
+		line += 1 + slidersPerLight + 1
+		if slider < 0 || slider >= slidersPerLight {
+			continue
+		}
+
+		m.detailRow = i*slidersPerLight + slider
+		cell := x - lipgloss.Width(sliderLabel(false, ""))
+		if cell < 0 || (cell >= barWidth && msg.Action == tea.MouseActionPress) {
+			return m, nil
+		}
+		if slider == sliderBrightness {
+			state.Brightness = barValue(cell, 3, 100, 1)
+		} else {
+			state.Temperature = barValue(cell, 2900, 7000, 50)
+		}
+		return m.setSlider(light, state)
+	}
+	return m, nil
+}
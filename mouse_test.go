@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"elgato-keylight/keylight"
+)
+
+// click presses the left button at x, y in the box content
+func click(t *testing.T, m model, x, y int, ctrl bool) model {
+	t.Helper()
+	next, _ := m.Update(tea.MouseMsg{
+		X:      x + boxStyle.GetBorderLeftSize() + boxStyle.GetPaddingLeft(),
+		Y:      y + boxStyle.GetBorderTopSize() + boxStyle.GetPaddingTop(),
+		Button: tea.MouseButtonLeft,
+		Action: tea.MouseActionPress,
+		Ctrl:   ctrl,
+	})
+	return next.(model)
+}
+
+func TestBarValue(t *testing.T) {
+	tests := []struct {
+		cell, lo, hi, step, want int
+	}{
+		{-5, 3, 100, 1, 3},
+		{barWidth - 1, 3, 100, 1, 100},
+		{barWidth + 10, 3, 100, 1, 100},
+		{barWidth - 1, 2900, 7000, 50, 7000},
+		{barWidth/2 - 1, 2900, 7000, 50, 4950},
+	}
+	for _, tt := range tests {
+		if got := barValue(tt.cell, tt.lo, tt.hi, tt.step); got != tt.want {
+			t.Errorf("barValue(%d, %d, %d, %d) = %d, want %d", tt.cell, tt.lo, tt.hi, tt.step, got, tt.want)
+		}
+	}
+}
+
+// Clicking a light selects only it, Ctrl-click adds one, and clicking a
+// group or "All lights" selects those; lights that share a name stay apart
+func TestClickLightList(t *testing.T) {
+	m := selectionModel(t)
+	_, layout := m.renderWithLayout()
+
+	m = click(t, m, 2, layout.lights+2, false)
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW002"}) {
+		t.Errorf("click on the second light: selected %v", got)
+	}
+	m = click(t, m, 2, layout.lights+1, true)
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW002"}) {
+		t.Errorf("ctrl-click on the first light: selected %v", got)
+	}
+	m = click(t, m, 2, layout.lights+4, false)
+	if got := selected(m); !reflect.DeepEqual(got, []string{"BW001", "BW003"}) || m.listCursor != 4 {
+		t.Errorf("click on @desk: selected %v, cursor %d", got, m.listCursor)
+	}
+	m = click(t, m, 2, layout.lights, false)
+	if got := selected(m); len(got) != 3 {
+		t.Errorf("click on all lights: selected %v", got)
+	}
+}
+
+// The wheel only moves a focused slider
+func TestWheel(t *testing.T) {
+	m := selectionModel(t)
+	m.brightnessValue = 50
+	wheel := func(button tea.MouseButton) {
+		next, _ := m.Update(tea.MouseMsg{Button: button, Action: tea.MouseActionPress})
+		m = next.(model)
+	}
+
+	m.focusedControl = focusToggle
+	wheel(tea.MouseButtonWheelUp)
+	if m.brightnessValue != 50 {
+		t.Errorf("wheel changed brightness to %d with the toggle focused", m.brightnessValue)
+	}
+	m.focusedControl = focusBrightness
+	wheel(tea.MouseButtonWheelUp)
+	if m.brightnessValue <= 50 {
+		t.Errorf("wheel up: brightness %d", m.brightnessValue)
+	}
+	up := m.brightnessValue
+	wheel(tea.MouseButtonWheelDown)
+	if m.brightnessValue >= up {
+		t.Errorf("wheel down: brightness %d after %d", m.brightnessValue, up)
+	}
+}
+
+// Pressing on a bar in the per-light view moves that light's slider
+func TestMouseDetail(t *testing.T) {
+	m := selectionModel(t)
+	state := keylight.State{On: true, Brightness: 50, Temperature: 4000}
+	next, _ := m.Update(stateMsg{
+		"BW001": {state: state, updated: time.Now()},
+		"BW002": {state: state, updated: time.Now()},
+	})
+	m = next.(model)
+	m.openDetail()
+	_, layout := m.renderWithLayout()
+
+	// The second light's temperature: its name, then brightness, after the
+	// first light's name, two sliders and a blank line
+	bar := lipgloss.Width(sliderLabel(false, ""))
+	m = click(t, m, bar+barWidth-1, layout.detail+4+1+sliderTemperature, false)
+	if m.detailRow != slidersPerLight+sliderTemperature {
+		t.Errorf("detailRow = %d", m.detailRow)
+	}
+	if m.sliders["BW002"].Temperature != 7000 || m.sliders["BW001"] != state {
+		t.Errorf("sliders = %+v", m.sliders)
+	}
+	if m.sliderEdits["BW002"] != 1 {
+		t.Errorf("sliderEdits = %v", m.sliderEdits)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"elgato-keylight/keylight"
+)
+
+// MQTT bridge defaults, overridden by the mqtt options
+const (
+	defaultMQTTBroker          = "tcp://localhost:1883"
+	defaultMQTTPrefix          = "keylight"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	mqttTimeout                = 5 * time.Second
+)
+
+// mqttOptions are the options of the mqtt command
+type mqttOptions struct {
+	Broker          string
+	Prefix          string // topics are <prefix>/<light>/state and so on
+	DiscoveryPrefix string // Home Assistant's discovery prefix; empty disables discovery
+	ClientID        string
+	Username        string
+	Password        string
+	Poll            time.Duration
+}
+
+// mqttState is a light's state as published on its state topic and
+// accepted on its set topic, in Home Assistant's JSON light schema.
+// Brightness is a percentage and ColorTemp is in mireds.
+type mqttState struct {
+	State      string `json:"state"` // ON or OFF
+	Brightness *int   `json:"brightness,omitempty"`
+	ColorMode  string `json:"color_mode,omitempty"`
+	ColorTemp  *int   `json:"color_temp,omitempty"`
+}
+
+// mqttBridge publishes the state of every configured light to MQTT and
+// applies the commands sent to the lights' set topics. Light state comes
+// from a server's cache, so the bridge and the REST API share the polling
+// and control code.
+type mqttBridge struct {
+	opts   mqttOptions
+	s      *server
+	client mqtt.Client
+
+	mu        sync.Mutex
+	published map[string]string // retained payload last published per topic
+	announced map[string]bool   // lights with a discovery config, by topic ID
+}
+
+// mqttID is a light's name in topics: its serial number, or its name with
+// the characters MQTT and Home Assistant treat specially replaced
+func mqttID(light *LightRecord) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, recordKey(light))
+}
+
+// Kelvin and mireds are each other's reciprocal, scaled by a million
+func kelvinToMireds(kelvin int) int {
+	return (1000000 + kelvin/2) / kelvin
+}
+
+func miredsToKelvin(mireds int) int {
+	return (1000000 + mireds/2) / mireds
+}
+
+func (b *mqttBridge) topic(parts ...string) string {
+	return strings.Join(append([]string{b.opts.Prefix}, parts...), "/")
+}
+
+// connect connects to the broker, retrying in the background until it
+// answers. Every (re)connection subscribes to the command topics and
+// publishes everything again.
+func (b *mqttBridge) connect() error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.opts.Broker).
+		SetClientID(b.opts.ClientID).
+		SetUsername(b.opts.Username).
+		SetPassword(b.opts.Password).
+		SetWill(b.topic("status"), "offline", 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false). // a slow light must not hold up the others
+		SetOnConnectHandler(func(client mqtt.Client) {
+			out.say("Connected to %s", b.opts.Broker)
+			// The broker may have lost what was retained
+			b.mu.Lock()
+			b.published = make(map[string]string)
+			b.announced = make(map[string]bool)
+			b.mu.Unlock()
+
+			client.Subscribe(b.topic("+", "set"), 1, b.handleSet)
+			if b.opts.DiscoveryPrefix != "" {
+				// Home Assistant asks for discovery again when it restarts
+				client.Subscribe(b.opts.DiscoveryPrefix+"/status", 1, func(_ mqtt.Client, msg mqtt.Message) {
+					if string(msg.Payload()) == "online" {
+						b.mu.Lock()
+						b.announced = make(map[string]bool)
+						b.mu.Unlock()
+						b.publishAll(context.Background())
+					}
+				})
+			}
+			b.publish(b.topic("status"), "online")
+			b.publishAll(context.Background())
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			out.warn("⚠ Lost connection to %s: %v", b.opts.Broker, err)
+		})
+
+	b.client = mqtt.NewClient(opts)
+	token := b.client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		out.warn("⚠ %s is not answering yet, still trying", b.opts.Broker)
+		return nil
+	}
+	return token.Error()
+}
+
+// publish publishes a retained payload, skipping it if the topic already
+// holds it
+func (b *mqttBridge) publish(topic, payload string) {
+	b.mu.Lock()
+	if last, ok := b.published[topic]; ok && last == payload {
+		b.mu.Unlock()
+		return
+	}
+	b.published[topic] = payload
+	b.mu.Unlock()
+
+	token := b.client.Publish(topic, 1, true, payload)
+	if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+		out.warn("⚠ Failed to publish to %s: %v", topic, token.Error())
+		b.mu.Lock()
+		delete(b.published, topic)
+		b.mu.Unlock()
+	}
+}
+
+// publishAll publishes every light's discovery config, availability and
+// state, and clears the topics of lights that were removed from the config
+func (b *mqttBridge) publishAll(ctx context.Context) {
+	config := b.s.currentConfig()
+	current := make(map[string]bool, len(config.Lights))
+	for _, light := range config.orderedLights() {
+		id := mqttID(light)
+		current[id] = true
+		b.announce(ctx, light)
+		b.publishLight(light)
+	}
+
+	b.mu.Lock()
+	var removed []string
+	for id := range b.announced {
+		if !current[id] {
+			removed = append(removed, id)
+			delete(b.announced, id)
+		}
+	}
+	b.mu.Unlock()
+	for _, id := range removed {
+		// An empty retained message deletes the retained one
+		b.publish(b.topic(id, "state"), "")
+		b.publish(b.topic(id, "availability"), "")
+		if b.opts.DiscoveryPrefix != "" {
+			b.publish(b.discoveryTopic(id), "")
+		}
+	}
+}
+
+func (b *mqttBridge) discoveryTopic(id string) string {
+	return b.opts.DiscoveryPrefix + "/light/" + b.opts.Prefix + "_" + id + "/config"
+}
+
+// announce publishes a light's Home Assistant discovery config, once
+func (b *mqttBridge) announce(ctx context.Context, light *LightRecord) {
+	id := mqttID(light)
+	b.mu.Lock()
+	done := b.announced[id]
+	b.announced[id] = true
+	b.mu.Unlock()
+	if done || b.opts.DiscoveryPrefix == "" {
+		return
+	}
+
+	device := map[string]any{
+		"identifiers":  []string{b.opts.Prefix + "_" + id},
+		"name":         light.Name,
+		"manufacturer": "Elgato",
+	}
+	// The model and firmware are nice to have; the light may be offline
+	if info, err := b.s.handle(light).Info(ctx); err == nil {
+		device["model"] = info.ProductName
+		device["sw_version"] = info.FirmwareVersion
+	}
+
+	discovery := map[string]any{
+		"name":                  nil, // the light is named after its device
+		"unique_id":             b.opts.Prefix + "_" + id,
+		"object_id":             b.opts.Prefix + "_" + id,
+		"schema":                "json",
+		"state_topic":           b.topic(id, "state"),
+		"command_topic":         b.topic(id, "set"),
+		"brightness":            true,
+		"brightness_scale":      keylight.MaxBrightness,
+		"supported_color_modes": []string{"color_temp"},
+		"min_mireds":            kelvinToMireds(keylight.MaxTemperature),
+		"max_mireds":            kelvinToMireds(keylight.MinTemperature),
+		"availability_mode":     "all",
+		"availability": []map[string]string{
+			{"topic": b.topic("status")},
+			{"topic": b.topic(id, "availability")},
+		},
+		"device": device,
+	}
+	data, _ := json.Marshal(discovery)
+	b.publish(b.discoveryTopic(id), string(data))
+}
+
+// publishLight publishes a light's availability and cached state
+func (b *mqttBridge) publishLight(light *LightRecord) {
+	id := mqttID(light)
+	c, ok := b.s.cached(light)
+	if c.err != nil {
+		b.publish(b.topic(id, "availability"), "offline")
+	}
+	if !ok {
+		return
+	}
+	if c.err == nil {
+		b.publish(b.topic(id, "availability"), "online")
+	}
+
+	state := mqttState{
+		State:      "OFF",
+		Brightness: keylight.Int(c.state.Brightness),
+		ColorMode:  "color_temp",
+		ColorTemp:  keylight.Int(kelvinToMireds(c.state.Temperature)),
+	}
+	if c.state.On {
+		state.State = "ON"
+	}
+	data, _ := json.Marshal(state)
+	b.publish(b.topic(id, "state"), string(data))
+}
+
+// handleSet applies a command sent to <prefix>/<light>/set: a JSON state
+// like the one published, or just ON, OFF or TOGGLE
+func (b *mqttBridge) handleSet(_ mqtt.Client, msg mqtt.Message) {
+	id := strings.TrimSuffix(strings.TrimPrefix(msg.Topic(), b.opts.Prefix+"/"), "/set")
+	config := b.s.currentConfig()
+	var light *LightRecord
+	for _, l := range config.orderedLights() {
+		if mqttID(l) == id {
+			light = l
+		}
+	}
+	if light == nil {
+		out.warn("⚠ Command for unknown light %s", id)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fanOutTimeout)
+	defer cancel()
+	if err := b.apply(ctx, light, strings.TrimSpace(string(msg.Payload()))); err != nil {
+		out.warn("⚠ %s: %v", light.Name, err)
+	}
+	b.publishLight(light)
+}
+
+// apply carries out a set command on a light
+func (b *mqttBridge) apply(ctx context.Context, light *LightRecord, payload string) error {
+	var cmd mqttState
+	switch strings.ToUpper(payload) {
+	case "TOGGLE":
+		return b.s.toggle(ctx, light)
+	case "ON", "OFF":
+		cmd.State = strings.ToUpper(payload)
+	default:
+		if err := json.Unmarshal([]byte(payload), &cmd); err != nil {
+			return fmt.Errorf("invalid command %q: use JSON, ON, OFF or TOGGLE", payload)
+		}
+	}
+
+	var patch keylight.Patch
+	switch strings.ToUpper(cmd.State) {
+	case "ON":
+		patch.On = keylight.Bool(true)
+	case "OFF":
+		patch.On = keylight.Bool(false)
+	case "TOGGLE":
+		return b.s.toggle(ctx, light)
+	case "":
+	default:
+		return fmt.Errorf("invalid state %q: use ON, OFF or TOGGLE", cmd.State)
+	}
+	if cmd.Brightness != nil {
+		patch.Brightness = keylight.Int(keylight.ClampBrightness(*cmd.Brightness))
+	}
+	if cmd.ColorTemp != nil && *cmd.ColorTemp > 0 {
+		patch.Temperature = keylight.Int(keylight.ClampTemperature(miredsToKelvin(*cmd.ColorTemp)))
+	}
+	if patch.On == nil && patch.Brightness == nil && patch.Temperature == nil {
+		return fmt.Errorf("nothing to change in %q", payload)
+	}
+
+	handle := b.s.handle(light)
+	if err := handle.Set(ctx, patch); err != nil {
+		return err
+	}
+	b.s.applied(ctx, light, handle, patch)
+	return nil
+}
+
+// run polls the lights and publishes their state until ctx is done
+func (b *mqttBridge) run(ctx context.Context) {
+	ticker := time.NewTicker(b.opts.Poll)
+	defer ticker.Stop()
+	for {
+		b.s.refresh(ctx, b.s.currentConfig().orderedLights())
+		if b.client.IsConnected() {
+			b.publishAll(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func cliMQTT(config *Config, args []string) {
+	const usage = "Usage: keylight mqtt [--broker tcp://localhost:1883] [--prefix keylight] [--discovery-prefix homeassistant|none]\n" +
+		"                     [--client-id <id>] [--username <user>] [--password <password>] [--poll 5s]"
+
+	host, _ := os.Hostname()
+	opts := mqttOptions{
+		Broker:          defaultMQTTBroker,
+		Prefix:          defaultMQTTPrefix,
+		DiscoveryPrefix: defaultMQTTDiscoveryPrefix,
+		ClientID:        "keylight-" + host,
+		Password:        os.Getenv("KEYLIGHT_MQTT_PASSWORD"),
+		Poll:            defaultPollInterval,
+	}
+	parsed, rest, err := cutOptions(args, []string{
+		"--broker", "--prefix", "--discovery-prefix", "--client-id", "--username", "--password", "--poll",
+	}, nil)
+	if err != nil {
+		out.fail(exitUsage, "%v\n%s", err, usage)
+	}
+	if len(rest) > 0 {
+		out.fail(exitUsage, "Unknown option: %s\n%s", rest[0], usage)
+	}
+	for _, opt := range parsed {
+		switch opt.name {
+		case "--broker":
+			opts.Broker = opt.value
+		case "--prefix":
+			opts.Prefix = strings.Trim(opt.value, "/")
+		case "--discovery-prefix":
+			opts.DiscoveryPrefix = strings.Trim(opt.value, "/")
+			if opt.value == "none" {
+				opts.DiscoveryPrefix = ""
+			}
+		case "--client-id":
+			opts.ClientID = opt.value
+		case "--username":
+			opts.Username = opt.value
+		case "--password":
+			opts.Password = opt.value
+		case "--poll":
+			d, err := time.ParseDuration(opt.value)
+			if err != nil || d <= 0 {
+				out.fail(exitUsage, "Invalid poll interval: %s", opt.value)
+			}
+			opts.Poll = d
+		}
+	}
+	if opts.Prefix == "" {
+		out.fail(exitUsage, "The topic prefix cannot be empty")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b := &mqttBridge{
+		opts:      opts,
+		s:         newServer(config),
+		published: make(map[string]string),
+		announced: make(map[string]bool),
+	}
+	if err := b.connect(); err != nil {
+		out.fail(exitFailed, "✗ Could not connect to %s: %v", opts.Broker, err)
+	}
+	out.say("Bridging %d light(s) to %s under %s/", len(config.Lights), opts.Broker, opts.Prefix)
+	b.run(ctx)
+
+	// Say goodbye instead of leaving it to the will
+	b.client.Publish(b.topic("status"), 1, true, "offline").WaitTimeout(mqttTimeout)
+	b.client.Disconnect(250)
+}
@@ -0,0 +1,132 @@
+//go:build integration
+
+// The MQTT integration test needs a broker. Start one and run
+//
+//	go test -tags integration -run MQTTBridge .
+//
+// It connects to tcp://localhost:1883, or to KEYLIGHT_TEST_BROKER if set
+// (e.g. "docker run -p 1883:1883 eclipse-mosquitto:2 mosquitto -c
+// /mosquitto-no-auth.conf"). Topics are under a prefix of their own, and
+// what the test retained is cleared when it ends.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestMQTTBridge(t *testing.T) {
+	broker := os.Getenv("KEYLIGHT_TEST_BROKER")
+	if broker == "" {
+		broker = defaultMQTTBroker
+	}
+	prefix := fmt.Sprintf("keylight-test-%d", time.Now().UnixNano())
+	discovery := prefix + "-ha"
+
+	// A client watching everything the bridge publishes
+	var mu sync.Mutex
+	retained := make(map[string]string)
+	changed := make(chan string, 64)
+	watch := func(_ mqtt.Client, msg mqtt.Message) {
+		mu.Lock()
+		retained[msg.Topic()] = string(msg.Payload())
+		mu.Unlock()
+		select {
+		case changed <- msg.Topic():
+		default:
+		}
+	}
+	watcher := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-watcher"))
+	if token := watcher.Connect(); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("connecting to %s: %v (is a broker running?)", broker, token.Error())
+	}
+	defer watcher.Disconnect(250)
+	watcher.Subscribe(prefix+"/#", 1, watch).WaitTimeout(mqttTimeout)
+	watcher.Subscribe(discovery+"/#", 1, watch).WaitTimeout(mqttTimeout)
+	defer func() {
+		mu.Lock()
+		var topics []string
+		for topic := range retained {
+			topics = append(topics, topic)
+		}
+		mu.Unlock()
+		for _, topic := range topics {
+			watcher.Publish(topic, 1, true, "").WaitTimeout(mqttTimeout)
+		}
+	}()
+
+	// waitFor waits until topic holds a payload that ok accepts
+	waitFor := func(topic string, ok func(string) bool) string {
+		t.Helper()
+		deadline := time.After(10 * time.Second)
+		for {
+			mu.Lock()
+			payload, seen := retained[topic]
+			mu.Unlock()
+			if seen && ok(payload) {
+				return payload
+			}
+			select {
+			case <-changed:
+			case <-deadline:
+				t.Fatalf("%s holds %q", topic, payload)
+			}
+		}
+	}
+
+	f, record := newFakeLight(t, "BW001", "Desk")
+	config := testConfig(t, record)
+	b := &mqttBridge{
+		opts: mqttOptions{
+			Broker:          broker,
+			Prefix:          prefix,
+			DiscoveryPrefix: discovery,
+			ClientID:        prefix + "-bridge",
+			Poll:            100 * time.Millisecond,
+		},
+		s:         newServer(config),
+		published: make(map[string]string),
+		announced: make(map[string]bool),
+	}
+	if err := b.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.client.Disconnect(250)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.run(ctx)
+
+	waitFor(prefix+"/status", func(p string) bool { return p == "online" })
+	waitFor(prefix+"/BW001/availability", func(p string) bool { return p == "online" })
+	announcement := waitFor(discovery+"/light/"+prefix+"_BW001/config", func(p string) bool { return p != "" })
+	var announced map[string]any
+	if err := json.Unmarshal([]byte(announcement), &announced); err != nil || announced["command_topic"] != prefix+"/BW001/set" {
+		t.Errorf("discovery config %s", announcement)
+	}
+	waitFor(prefix+"/BW001/state", func(p string) bool { return strings.Contains(p, `"state":"ON"`) })
+
+	// A command reaches the light and its new state is published
+	watcher.Publish(prefix+"/BW001/set", 1, false, `{"state": "ON", "brightness": 30, "color_temp": 200}`).WaitTimeout(mqttTimeout)
+	state := waitFor(prefix+"/BW001/state", func(p string) bool { return strings.Contains(p, `"brightness":30`) })
+	if !strings.Contains(state, `"color_temp":200`) {
+		t.Errorf("state %s", state)
+	}
+	if got := f.current(); !got.On || got.Brightness != 30 || got.Temperature != 5000 {
+		t.Errorf("light = %+v", got)
+	}
+
+	watcher.Publish(prefix+"/BW001/set", 1, false, "OFF").WaitTimeout(mqttTimeout)
+	waitFor(prefix+"/BW001/state", func(p string) bool { return strings.Contains(p, `"state":"OFF"`) })
+	if f.current().On {
+		t.Error("light still on after OFF")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"elgato-keylight/keylight"
+)
+
+func TestMQTTID(t *testing.T) {
+	tests := []struct {
+		light LightRecord
+		want  string
+	}{
+		{LightRecord{Serial: "BW12K1A01234", Name: "Desk"}, "BW12K1A01234"},
+		{LightRecord{Name: "Key_Light-2"}, "Key_Light-2"},
+		{LightRecord{Name: "Desk Left"}, "Desk_Left"},
+		{LightRecord{Name: "a/b+c#d"}, "a_b_c_d"}, // topic separators and wildcards
+		{LightRecord{Name: "Küche"}, "K_che"},
+	}
+	for _, tt := range tests {
+		if got := mqttID(&tt.light); got != tt.want {
+			t.Errorf("mqttID(%+v) = %q, want %q", tt.light, got, tt.want)
+		}
+	}
+}
+
+func TestMireds(t *testing.T) {
+	tests := []struct {
+		kelvin, mireds int
+	}{
+		{4000, 250},
+		{5000, 200},
+		{keylight.MaxTemperature, 143},
+		{keylight.MinTemperature, 345},
+	}
+	for _, tt := range tests {
+		if got := kelvinToMireds(tt.kelvin); got != tt.mireds {
+			t.Errorf("kelvinToMireds(%d) = %d, want %d", tt.kelvin, got, tt.mireds)
+		}
+	}
+
+	// Rounding loses a little, but a round trip stays close
+	for kelvin := keylight.MinTemperature; kelvin <= keylight.MaxTemperature; kelvin += 50 {
+		back := miredsToKelvin(kelvinToMireds(kelvin))
+		if diff := back - kelvin; diff < -25 || diff > 25 {
+			t.Errorf("%dK round trips to %dK", kelvin, back)
+		}
+	}
+}
+
+func TestMQTTApply(t *testing.T) {
+	on := func(brightness, kelvin int) keylight.State {
+		return keylight.State{On: true, Brightness: brightness, Temperature: kelvin}
+	}
+	off := func(brightness, kelvin int) keylight.State {
+		return keylight.State{Brightness: brightness, Temperature: kelvin}
+	}
+	// The light stores temperatures in its own, coarser scale
+	stored := func(kelvin int) int {
+		return keylight.DeviceToKelvin(keylight.KelvinToDevice(kelvin))
+	}
+
+	// Every light starts on at 50% and 4000K
+	tests := []struct {
+		payload string
+		want    keylight.State
+		err     string // part of the error, if the command is refused
+	}{
+		{"OFF", off(50, 4000), ""},
+		{"on", on(50, 4000), ""},
+		{"TOGGLE", off(50, 4000), ""},
+		{`{"state": "OFF"}`, off(50, 4000), ""},
+		{`{"state": "toggle"}`, off(50, 4000), ""},
+		{`{"brightness": 30}`, on(30, 4000), ""},
+		{`{"state": "ON", "brightness": 150, "color_temp": 200}`, on(100, 5000), ""},
+		{`{"brightness": 0}`, on(keylight.MinBrightness, 4000), ""},
+		{`{"color_temp": 500}`, on(50, stored(keylight.MinTemperature)), ""},
+		{`{"color_temp": 100}`, on(50, stored(keylight.MaxTemperature)), ""},
+		{"DIM", on(50, 4000), "invalid command"},
+		{`{"state": "DIM"}`, on(50, 4000), "invalid state"},
+		{`{}`, on(50, 4000), "nothing to change"},
+		{`{"color_temp": 0}`, on(50, 4000), "nothing to change"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			f, record := newFakeLight(t, "BW001", "Desk")
+			config := testConfig(t, record)
+			b := &mqttBridge{opts: mqttOptions{Prefix: defaultMQTTPrefix}, s: newServer(config)}
+
+			err := b.apply(context.Background(), record, tt.payload)
+			if tt.err == "" && err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("apply() error = %v, want %q", err, tt.err)
+			}
+			if got := f.current(); got != tt.want {
+				t.Errorf("light = %+v, want %+v", got, tt.want)
+			}
+			// What was applied is what the bridge publishes next
+			if c, ok := b.s.cached(record); err == nil && (!ok || c.state != tt.want) {
+				t.Errorf("cached %+v, want %+v", c.state, tt.want)
+			}
+		})
+	}
+}
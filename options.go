@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// option is a command-line option as given: its name, with dashes, and its
+// value, if it takes one
+type option struct {
+	name, value string
+}
+
+// cutOptions takes the options named in valued and switches out of args.
+// Options in valued take a value, given as "--opt value" or "--opt=value";
+// switches take none. The options are returned in the order given, followed
+// by the arguments that are not theirs.
+func cutOptions(args, valued, switches []string) ([]option, []string, error) {
+	var opts []option
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		switch {
+		case slices.Contains(switches, name):
+			if hasValue {
+				return nil, nil, fmt.Errorf("%s takes no value", name)
+			}
+		case slices.Contains(valued, name):
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("missing value for %s", name)
+				}
+				i++
+				value = args[i]
+			}
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+		opts = append(opts, option{name: name, value: value})
+	}
+	return opts, rest, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCutOptions(t *testing.T) {
+	valued := []string{"--timeout", "--interface"}
+	switches := []string{"--replace"}
+	tests := []struct {
+		args     []string
+		wantOpts []option
+		wantRest []string
+		wantErr  bool
+	}{
+		{nil, nil, []string{}, false},
+		{[]string{"--timeout", "5s"}, []option{{"--timeout", "5s"}}, []string{}, false},
+		{[]string{"--timeout=5s"}, []option{{"--timeout", "5s"}}, []string{}, false},
+		{[]string{"--replace", "--interface", "eth0", "--interface=wlan0"},
+			[]option{{"--replace", ""}, {"--interface", "eth0"}, {"--interface", "wlan0"}}, []string{}, false},
+		{[]string{"on", "--timeout", "1s", "desk"}, []option{{"--timeout", "1s"}}, []string{"on", "desk"}, false},
+		// A value that looks like an option is still the value
+		{[]string{"--interface", "--replace"}, []option{{"--interface", "--replace"}}, []string{}, false},
+		{[]string{"--other", "x"}, nil, []string{"--other", "x"}, false},
+		{[]string{"--timeout"}, nil, nil, true},
+		{[]string{"--replace=yes"}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		opts, rest, err := cutOptions(tt.args, valued, switches)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("cutOptions(%q) error = %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tt.wantOpts) || (!tt.wantErr && !reflect.DeepEqual(rest, tt.wantRest)) {
+			t.Errorf("cutOptions(%q) = %v, %q; want %v, %q", tt.args, opts, rest, tt.wantOpts, tt.wantRest)
+		}
+	}
+}
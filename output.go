@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"elgato-keylight/keylight"
+)
+
+// Output formats, chosen with --json or --output
+const (
+	formatText  = "text" // the human-readable lines, printed as they happen
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+	formatTable = "table"
+)
+
+// Exit codes
+const (
+	exitOK        = 0   // every light succeeded
+	exitFailed    = 1   // every light failed, or the command could not be carried out
+	exitUsage     = 2   // bad arguments
+	exitPartial   = 3   // some lights succeeded and some failed
+	exitNoLights  = 4   // no lights configured
+	exitNotFound  = 5   // the named light, group or scene does not exist
+	exitCancelled = 130 // interrupted with Ctrl-C (128 + SIGINT, as a shell reports it)
+)
+
+// lightResult is what a command did to, or found out about, one light
+type lightResult struct {
+	Index      int                     `json:"index,omitempty"` // position in list
+	Name       string                  `json:"name"`
+	Serial     string                  `json:"serial,omitempty"`
+	Address    string                  `json:"address,omitempty"`
+	Stale      bool                    `json:"stale,omitempty"`
+	Change     string                  `json:"change,omitempty"` // detect: added, updated, stale or removed
+	OK         bool                    `json:"ok"`
+	Error      string                  `json:"error,omitempty"`
+	State      *keylight.State         `json:"state,omitempty"` // state after the command
+	Info       *keylight.AccessoryInfo `json:"info,omitempty"`
+	DurationMS int64                   `json:"durationMs"`
+}
+
+func newLightResult(light *LightRecord) lightResult {
+	return lightResult{Name: light.Name, Serial: light.Serial, Address: light.Addr(), OK: true}
+}
+
+// done records how a request that started at start ended
+func (r *lightResult) done(start time.Time, err error) {
+	r.DurationMS = time.Since(start).Milliseconds()
+	r.OK = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// groupResult is a group as reported by group list
+type groupResult struct {
+	Name   string   `json:"name"`
+	Lights []string `json:"lights"`
+}
+
+// sceneEntry is a scene as reported by scene list
+type sceneEntry struct {
+	Name   string        `json:"name"`
+	Lights []lightResult `json:"lights"`
+}
+
+// commandResult is the document printed in the structured formats
+type commandResult struct {
+	Command    string        `json:"command"`
+	Args       []string      `json:"args,omitempty"`
+	OK         bool          `json:"ok"`
+	Error      string        `json:"error,omitempty"`
+	Warnings   []string      `json:"warnings,omitempty"`
+	Lights     []lightResult `json:"lights,omitempty"`
+	Groups     []groupResult `json:"groups,omitempty"`
+	Scenes     []sceneEntry  `json:"scenes,omitempty"`
+	ExitCode   int           `json:"exitCode"`
+	DurationMS int64         `json:"durationMs"`
+}
+
+// output collects what a CLI command did. In text mode each line is printed
+// as it is produced; in the structured formats results are collected and
+// printed as one document when the command ends.
+type output struct {
+	mu        sync.Mutex
+	format    string
+	start     time.Time
+	result    commandResult
+	succeeded int // light results, for the exit code
+	failed    int
+}
+
+// out is the output of the running CLI command
+var out = &output{format: formatText, start: time.Now()}
+
+// parseOutputFlags removes --json and --output (-o) from args, returning the
+// format and the remaining arguments
+func parseOutputFlags(args []string) (string, []string, error) {
+	format := formatText
+	flags, rest, err := cutOptions(args, []string{"--output", "-o"}, []string{"--json"})
+	if err != nil {
+		return "", nil, err
+	}
+	for _, flag := range flags {
+		if flag.name == "--json" {
+			format = formatJSON
+			continue
+		}
+		switch flag.value {
+		case formatText, formatJSON, formatYAML, formatTable:
+			format = flag.value
+		default:
+			return "", nil, fmt.Errorf("unknown output format: %s (use text, json, yaml or table)", flag.value)
+		}
+	}
+	return format, rest, nil
+}
+
+// structured reports whether results are printed as a document
+func (o *output) structured() bool {
+	return o.format != formatText
+}
+
+// say prints an informational line in text mode
+func (o *output) say(format string, args ...any) {
+	if !o.structured() {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// light records the result for one light. In text mode the line is printed,
+// to stderr with the cause appended if the light failed.
+func (o *output) light(r lightResult, format string, args ...any) {
+	o.add(r)
+	if o.structured() {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if r.OK {
+		fmt.Printf(format+"\n", args...)
+	} else {
+		fmt.Fprintf(os.Stderr, format+": %s\n", append(args, r.Error)...)
+	}
+}
+
+// add records the result for one light without a text line
+func (o *output) add(r lightResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if r.OK {
+		o.succeeded++
+	} else {
+		o.failed++
+	}
+	if o.structured() {
+		o.result.Lights = append(o.result.Lights, r)
+	}
+}
+
+// warn reports something worth knowing that did not stop the command
+func (o *output) warn(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.structured() {
+		o.result.Warnings = append(o.result.Warnings, strings.TrimLeft(msg, "⚠ \n"))
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+// fail reports an error that stops the command and exits with code
+func (o *output) fail(code int, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if o.structured() {
+		o.result.Error = strings.TrimLeft(msg, "✗⚠ \n")
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	o.exit(code)
+}
+
+// lookupFailure is the exit code for a light that could not be found: a
+// name that several lights share is bad usage, anything else is not found
+func lookupFailure(err error) int {
+	if errors.Is(err, errAmbiguousName) {
+		return exitUsage
+	}
+	return exitNotFound
+}
+
+// exitCode is the code for a command that ran to the end: whether all,
+// some or none of its lights succeeded
+func (o *output) exitCode() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case o.failed == 0:
+		return exitOK
+	case o.succeeded == 0:
+		return exitFailed
+	}
+	return exitPartial
+}
+
+// exit prints the collected result and exits with code
+func (o *output) exit(code int) {
+	o.result.ExitCode = code
+	o.flush()
+	os.Exit(code)
+}
+
+// stateAfter reads a light's state after a change, for the structured
+// formats; text mode does not show it, so it skips the request
+func (o *output) stateAfter(ctx context.Context, light keylight.Light) *keylight.State {
+	if !o.structured() {
+		return nil
+	}
+	state, err := light.State(ctx)
+	if err != nil {
+		return nil
+	}
+	return &state
+}
+
+// flush prints the collected result in the structured formats
+func (o *output) flush() {
+	if !o.structured() {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	r := &o.result
+	r.DurationMS = time.Since(o.start).Milliseconds()
+	r.OK = r.Error == ""
+	for _, light := range r.Lights {
+		r.OK = r.OK && light.OK
+	}
+
+	switch o.format {
+	case formatJSON:
+		data, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(data))
+	case formatYAML:
+		fmt.Print(toYAML(r))
+	case formatTable:
+		printTable(r)
+	}
+}
+
+// toYAML renders v as YAML with the same field names and order as its JSON
+func toYAML(v any) string {
+	data, _ := json.Marshal(v)
+	// JSON is YAML; decoding it into a node keeps the key order
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return ""
+	}
+	blockStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	enc.Encode(&node)
+	return buf.String()
+}
+
+// blockStyle drops the flow style YAML gives JSON objects and arrays
+func blockStyle(node *yaml.Node) {
+	node.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// tableColumn is a column of the light table, shown only when some light
+// has a value for it
+type tableColumn struct {
+	header string
+	value  func(lightResult) string
+}
+
+var tableColumns = []tableColumn{
+	{"#", func(r lightResult) string { return itoaNonZero(r.Index) }},
+	{"LIGHT", func(r lightResult) string { return r.Name }},
+	{"SERIAL", func(r lightResult) string { return r.Serial }},
+	{"ADDRESS", func(r lightResult) string { return r.Address }},
+	{"CHANGE", func(r lightResult) string { return r.Change }},
+	{"POWER", func(r lightResult) string {
+		if r.State == nil {
+			return ""
+		}
+		if r.State.On {
+			return "on"
+		}
+		return "off"
+	}},
+	{"BRIGHTNESS", func(r lightResult) string {
+		if r.State == nil {
+			return ""
+		}
+		return fmt.Sprintf("%d%%", r.State.Brightness)
+	}},
+	{"TEMPERATURE", func(r lightResult) string {
+		if r.State == nil {
+			return ""
+		}
+		return fmt.Sprintf("%dK", r.State.Temperature)
+	}},
+	{"PRODUCT", func(r lightResult) string {
+		if r.Info == nil {
+			return ""
+		}
+		return r.Info.ProductName
+	}},
+	{"FIRMWARE", func(r lightResult) string {
+		if r.Info == nil {
+			return ""
+		}
+		return r.Info.FirmwareVersion
+	}},
+	{"RESULT", func(r lightResult) string {
+		if r.OK {
+			return "ok"
+		}
+		return "error: " + r.Error
+	}},
+	{"TIME", func(r lightResult) string { return fmt.Sprintf("%dms", r.DurationMS) }},
+}
+
+func itoaNonZero(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// printTable prints the result as aligned columns
+func printTable(r *commandResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if len(r.Lights) > 0 {
+		var columns []tableColumn
+		for _, col := range tableColumns {
+			for _, light := range r.Lights {
+				if col.value(light) != "" {
+					columns = append(columns, col)
+					break
+				}
+			}
+		}
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = col.header
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+		for _, light := range r.Lights {
+			for i, col := range columns {
+				row[i] = col.value(light)
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+
+	if len(r.Groups) > 0 {
+		fmt.Fprintln(w, "GROUP\tLIGHTS")
+		for _, g := range r.Groups {
+			fmt.Fprintf(w, "@%s\t%s\n", g.Name, strings.Join(g.Lights, ", "))
+		}
+	}
+
+	if len(r.Scenes) > 0 {
+		fmt.Fprintln(w, "SCENE\tLIGHT\tPOWER\tBRIGHTNESS\tTEMPERATURE")
+		for _, s := range r.Scenes {
+			for _, light := range s.Lights {
+				power := "off"
+				if light.State.On {
+					power = "on"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d%%\t%dK\n", s.Name, light.Name, power, light.State.Brightness, light.State.Temperature)
+			}
+		}
+	}
+
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", warning)
+	}
+	if r.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", r.Error)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantFormat string
+		wantRest   []string
+		wantErr    bool
+	}{
+		{"none", []string{"on", "1"}, formatText, []string{"on", "1"}, false},
+		{"json", []string{"list", "--json"}, formatJSON, []string{"list"}, false},
+		{"output yaml", []string{"--output=yaml", "status"}, formatYAML, []string{"status"}, false},
+		{"short output", []string{"status", "-o", "table"}, formatTable, []string{"status"}, false},
+		{"last one wins", []string{"--json", "--output", "text", "list"}, formatText, []string{"list"}, false},
+		{"unknown format", []string{"list", "--output", "xml"}, "", nil, true},
+		{"missing format", []string{"list", "-o"}, "", nil, true},
+		{"json takes no value", []string{"--json=yes", "list"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, rest, err := parseOutputFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOutputFlags(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if format != tt.wantFormat || !reflect.DeepEqual(rest, tt.wantRest) {
+				t.Errorf("parseOutputFlags(%q) = %q, %q; want %q, %q", tt.args, format, rest, tt.wantFormat, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		succeeded, failed int
+		want              int
+	}{
+		{0, 0, exitOK},
+		{3, 0, exitOK},
+		{2, 1, exitPartial},
+		{0, 2, exitFailed},
+	}
+	for _, tt := range tests {
+		o := &output{succeeded: tt.succeeded, failed: tt.failed}
+		if got := o.exitCode(); got != tt.want {
+			t.Errorf("exitCode() with %d succeeded, %d failed = %d, want %d", tt.succeeded, tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestLookupFailure(t *testing.T) {
+	if got := lookupFailure(fmt.Errorf("light 'x' not found")); got != exitNotFound {
+		t.Errorf("not found: got %d, want %d", got, exitNotFound)
+	}
+	if got := lookupFailure(fmt.Errorf("%w: Desk", errAmbiguousName)); got != exitUsage {
+		t.Errorf("ambiguous: got %d, want %d", got, exitUsage)
+	}
+}
+
+// TestCLIExitCodes runs the CLI in a child process, since commands end by
+// exiting. The child is this test binary, told what to run by
+// KEYLIGHT_TEST_ARGS.
+func TestCLIExitCodes(t *testing.T) {
+	if args := os.Getenv("KEYLIGHT_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"keylight"}, strings.Fields(args)...)
+		main()
+		return
+	}
+
+	_, desk := newFakeLight(t, "BW001", "Desk")
+	gone := &LightRecord{Serial: "BW002", Name: "Gone", IP: "127.0.0.1", Port: 1}
+	saveConfig(testConfig(t, desk, gone))
+	home := os.Getenv("HOME")
+
+	tests := []struct {
+		args string
+		home string
+		want int
+	}{
+		{"Desk on", home, exitOK},
+		{"Gone on", home, exitFailed},
+		{"on", home, exitPartial},
+		{"on --output xml", home, exitUsage},
+		{"bright 200", home, exitUsage},
+		{"move Nowhere 1", home, exitNotFound},
+		{"@nowhere on", home, exitNotFound},
+		{"on", t.TempDir(), exitNoLights},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestCLIExitCodes$")
+			cmd.Env = append(os.Environ(), "KEYLIGHT_TEST_ARGS="+tt.args, "HOME="+tt.home)
+			err := cmd.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.want {
+				t.Errorf("keylight %s exited with %d, want %d", tt.args, code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+
+	"elgato-keylight/keylight"
+)
+
+// resolveTimeout bounds the mDNS lookup for a light that stopped answering
+const resolveTimeout = 2 * time.Second
+
+// configMu guards light addresses and config writes made while lights are
+// being re-resolved from concurrent goroutines
+var configMu sync.Mutex
+
+// resolveBackoff is how long a handle waits after looking a light up
+// before it looks it up again, so retry loops stay fast while a light that
+// is still missing is found once it is back
+const resolveBackoff = 30 * time.Second
+
+// resolvingLight is a keylight.Light for a configured light. When the
+// stored address stops answering, the light is looked up again over mDNS;
+// if it turns up somewhere else the move is recorded and the request is
+// retried once. Handles are safe for concurrent use: they work from a copy
+// of the record, which only Config.relocateLight changes.
+type resolvingLight struct {
+	record   *LightRecord // the configured light, passed to relocate
+	light    LightRecord  // copy of the record when the handle was made
+	opts     discoveryOptions
+	relocate func(light *LightRecord, ip string, port int)
+	moved    func(light *LightRecord, oldAddr, newAddr string)
+
+	mu          sync.Mutex
+	addr        string    // where the light was last found
+	nextResolve time.Time // when the light may be looked up again
+}
+
+// lightHandle returns a handle for a configured light, going through the
+// daemon when one is running. moved, if set, is called when the light is
+// found at a new address.
+func (c *Config) lightHandle(light *LightRecord, moved func(light *LightRecord, oldAddr, newAddr string)) keylight.Light {
+	configMu.Lock()
+	direct := &resolvingLight{
+		record:   light,
+		light:    *light,
+		opts:     c.discoveryOptions(resolveTimeout),
+		relocate: c.relocateLight,
+		moved:    moved,
+		addr:     light.Addr(),
+	}
+	configMu.Unlock()
+	if daemon != nil {
+		return daemon.light(light, direct)
+	}
+	return direct
+}
+
+// lightHandles maps each light's key to a handle for it
+func (c *Config) lightHandles(lights []*LightRecord, moved func(light *LightRecord, oldAddr, newAddr string)) map[string]keylight.Light {
+	handles := make(map[string]keylight.Light, len(lights))
+	for _, light := range lights {
+		handles[recordKey(light)] = c.lightHandle(light, moved)
+	}
+	return handles
+}
+
+// relocateLight records that a light answers at ip, and at port unless it
+// is 0. It runs on the goroutine whose request found the light. The TUI
+// shares records with its other goroutines, so it sets relocate to record
+// the move its own way; otherwise the record is updated and the config
+// saved here.
+func (c *Config) relocateLight(light *LightRecord, ip string, port int) {
+	if c.relocate != nil {
+		c.relocate(light, ip, port)
+		return
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	light.IP = ip
+	light.Stale = false
+	if port != 0 {
+		light.Port = port
+	}
+	saveConfig(c)
+}
+
+func (l *resolvingLight) State(ctx context.Context) (keylight.State, error) {
+	var state keylight.State
+	err := l.retry(ctx, func(light keylight.Light) (err error) {
+		state, err = light.State(ctx)
+		return err
+	}, "state")
+	return state, err
+}
+
+func (l *resolvingLight) Set(ctx context.Context, p keylight.Patch) error {
+	return l.retry(ctx, func(light keylight.Light) error {
+		return light.Set(ctx, p)
+	}, "set")
+}
+
+func (l *resolvingLight) Toggle(ctx context.Context) (bool, error) {
+	var on bool
+	err := l.retry(ctx, func(light keylight.Light) (err error) {
+		on, err = light.Toggle(ctx)
+		return err
+	}, "toggle")
+	return on, err
+}
+
+func (l *resolvingLight) Info(ctx context.Context) (keylight.AccessoryInfo, error) {
+	var info keylight.AccessoryInfo
+	err := l.retry(ctx, func(light keylight.Light) (err error) {
+		info, err = light.Info(ctx)
+		return err
+	}, "info")
+	return info, err
+}
+
+// retry runs fn against the light's address and, if the light could not be
+// reached, once more against the address found by re-resolving it. op names
+// the request in the metrics.
+func (l *resolvingLight) retry(ctx context.Context, fn func(keylight.Light) error, op string) error {
+	l.mu.Lock()
+	oldAddr := l.addr
+	l.mu.Unlock()
+
+	start := time.Now()
+	err := fn(client.Light(oldAddr))
+	observeRequest(&l.light, op, start, err)
+	if err == nil || !keylight.IsUnreachable(err) {
+		return err
+	}
+
+	l.mu.Lock()
+	if time.Now().Before(l.nextResolve) {
+		l.mu.Unlock()
+		return err
+	}
+	l.nextResolve = time.Now().Add(resolveBackoff)
+	l.mu.Unlock()
+
+	ip, port, ok := resolveLight(ctx, &l.light, l.opts)
+	if !ok {
+		return err
+	}
+	found := l.light
+	found.IP = ip
+	if port != 0 {
+		found.Port = port
+	}
+	newAddr := found.Addr()
+	if newAddr == oldAddr {
+		return err
+	}
+
+	l.mu.Lock()
+	l.addr = newAddr
+	l.mu.Unlock()
+	l.relocate(l.record, ip, port)
+	if l.moved != nil {
+		l.moved(&l.light, oldAddr, newAddr)
+	}
+	lightRetries.WithLabelValues(l.light.Name, l.light.Serial, op).Inc()
+	start = time.Now()
+	err = fn(client.Light(newAddr))
+	observeRequest(&l.light, op, start, err)
+	return err
+}
+
+// resolveLight finds the current address of a configured light with a
+// targeted mDNS query for its instance name, falling back to resolving its
+// hostname
+func resolveLight(ctx context.Context, light *LightRecord, opts discoveryOptions) (ip string, port int, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	if light.Instance != "" {
+		if ip, port, ok := lookupInstance(ctx, light.Instance, opts); ok {
+			return ip, port, true
+		}
+	}
+
+	if light.Hostname != "" {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, strings.TrimSuffix(light.Hostname, "."))
+		if err == nil && len(addrs) > 0 {
+			return addrs[0], 0, true
+		}
+	}
+
+	return "", 0, false
+}
+
+// lookupInstance queries mDNS for a single _elg._tcp instance
+func lookupInstance(ctx context.Context, instance string, opts discoveryOptions) (ip string, port int, ok bool) {
+	resolver, err := opts.newResolver()
+	if err != nil {
+		return "", 0, false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Lookup(ctx, instance, "_elg._tcp", "local.", entries); err != nil {
+		return "", 0, false
+	}
+
+	// Keep draining until the resolver closes entries after cancel
+	for entry := range entries {
+		if ok {
+			continue
+		}
+		if ip, ok = opts.entryIP(entry); ok {
+			port = entry.Port
+			cancel()
+		}
+	}
+	return ip, port, ok
+}
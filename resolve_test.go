@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+// fakeLight is a light's HTTP API, for tests that drive lights through
+// handles
+type fakeLight struct {
+	mu    sync.Mutex
+	state keylight.State
+	puts  int
+}
+
+func (f *fakeLight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/elgato/lights" && r.Method == http.MethodGet:
+		on := 0
+		if f.state.On {
+			on = 1
+		}
+		json.NewEncoder(w).Encode(map[string]any{"lights": []map[string]int{{
+			"on": on, "brightness": f.state.Brightness, "temperature": keylight.KelvinToDevice(f.state.Temperature),
+		}}})
+	case r.URL.Path == "/elgato/lights" && r.Method == http.MethodPut:
+		var body struct {
+			Lights []struct {
+				On          *int `json:"on"`
+				Brightness  *int `json:"brightness"`
+				Temperature *int `json:"temperature"`
+			} `json:"lights"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.puts++
+		for _, l := range body.Lights {
+			if l.On != nil {
+				f.state.On = *l.On == 1
+			}
+			if l.Brightness != nil {
+				f.state.Brightness = *l.Brightness
+			}
+			if l.Temperature != nil {
+				f.state.Temperature = keylight.DeviceToKelvin(*l.Temperature)
+			}
+		}
+	case r.URL.Path == "/elgato/accessory-info":
+		json.NewEncoder(w).Encode(keylight.AccessoryInfo{ProductName: "Elgato Key Light", SerialNumber: "BW001"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeLight) current() keylight.State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state
+}
+
+// newFakeLight starts a fake light on 127.0.0.1 and returns it with a
+// record for it
+func newFakeLight(t *testing.T, serial, name string) (*fakeLight, *LightRecord) {
+	t.Helper()
+	f := &fakeLight{state: keylight.State{On: true, Brightness: 50, Temperature: 4000}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return f, &LightRecord{Serial: serial, Name: name, IP: host, Port: p}
+}
+
+// testConfig returns a config holding lights, with the config file in a
+// temporary home directory
+func testConfig(t *testing.T, lights ...*LightRecord) *Config {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	config := defaultConfig()
+	for _, light := range lights {
+		config.Lights[recordKey(light)] = light
+	}
+	config.normalizeOrder()
+	return config
+}
+
+// Handles are shared by the TUI's commands and the servers' requests
+func TestResolvingLightConcurrent(t *testing.T) {
+	f, record := newFakeLight(t, "BW001", "Desk")
+	// The stored address stops answering; the light is found again at its
+	// hostname
+	record.Hostname = record.IP
+	record.IP = "127.0.0.2"
+	config := testConfig(t, record)
+
+	var mu sync.Mutex
+	var moves []string
+	config.relocate = func(light *LightRecord, ip string, port int) {
+		mu.Lock()
+		defer mu.Unlock()
+		moves = append(moves, ip)
+	}
+	light := config.lightHandle(record, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func() { defer wg.Done(); light.State(context.Background()) }()
+		go func() {
+			defer wg.Done()
+			light.Set(context.Background(), keylight.Patch{Brightness: keylight.Int(20 + i)})
+		}()
+		go func() { defer wg.Done(); light.Toggle(context.Background()) }()
+		go func() { defer wg.Done(); light.Info(context.Background()) }()
+	}
+	wg.Wait()
+
+	if len(moves) != 1 || moves[0] != record.Hostname {
+		t.Errorf("relocated %v, want once to %s", moves, record.Hostname)
+	}
+	if record.IP != "127.0.0.2" {
+		t.Errorf("record changed to %s; only relocate may change it", record.IP)
+	}
+	if err := light.Set(context.Background(), keylight.Patch{Brightness: keylight.Int(70)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.current().Brightness; got != 70 {
+		t.Errorf("brightness = %d, want 70", got)
+	}
+}
+
+// A light that could not be found is looked up again once resolveBackoff
+// has passed, rather than never
+func TestResolvingLightRetryAfter(t *testing.T) {
+	_, record := newFakeLight(t, "BW001", "Desk")
+	addr := record.IP
+	record.IP = "127.0.0.2"
+	config := testConfig(t, record)
+	relocated := 0
+	config.relocate = func(*LightRecord, string, int) { relocated++ }
+	light := config.lightHandle(record, nil).(*resolvingLight)
+
+	// Nothing to look the light up by
+	if _, err := light.State(context.Background()); !keylight.IsUnreachable(err) {
+		t.Fatalf("State() error = %v, want unreachable", err)
+	}
+	if !light.nextResolve.After(time.Now()) {
+		t.Fatal("no retry time set after a failed lookup")
+	}
+
+	// Found by hostname now, but not looked up again until the backoff ends
+	light.light.Hostname = addr
+	if _, err := light.State(context.Background()); err == nil || relocated != 0 {
+		t.Fatalf("looked up again during the backoff: err = %v, relocated %d", err, relocated)
+	}
+	light.nextResolve = time.Now()
+	if _, err := light.State(context.Background()); err != nil {
+		t.Fatalf("State() after the backoff: %v", err)
+	}
+	if relocated != 1 {
+		t.Errorf("relocated %d times, want 1", relocated)
+	}
+}
+
+// Lights that share a name, like two still called "Elgato Key Light", each
+// get a handle of their own
+func TestLightHandlesSameName(t *testing.T) {
+	left := &LightRecord{Serial: "BW001", Name: "Elgato Key Light", IP: "10.0.0.5"}
+	right := &LightRecord{Serial: "BW002", Name: "Elgato Key Light", IP: "10.0.0.6"}
+	config := testConfig(t, left, right)
+	handles := config.lightHandles(config.orderedLights(), nil)
+	if len(handles) != 2 {
+		t.Fatalf("got %d handles, want 2", len(handles))
+	}
+	for key, light := range map[string]*LightRecord{"BW001": left, "BW002": right} {
+		if h, ok := handles[key].(*resolvingLight); !ok || h.record != light {
+			t.Errorf("handle for %s = %+v", key, handles[key])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"elgato-keylight/keylight"
+)
+
+const (
+	// scanWorkers bounds how many hosts are probed at once
+	scanWorkers = 64
+	// scanProbeTimeout is how long each host gets to answer
+	scanProbeTimeout = 1 * time.Second
+	// scanMaxHosts keeps a typo like /8 from probing millions of hosts
+	scanMaxHosts = 1 << 16
+)
+
+// scanSubnet probes every host in cidr for the Elgato API, for networks
+// where multicast is filtered. onFound (if set) is called as lights answer;
+// calls are serialised.
+func scanSubnet(cidr string, onFound func(discoveredLight)) ([]discoveredLight, error) {
+	hosts, err := subnetHosts(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := keylight.NewClient(keylight.WithTimeout(scanProbeTimeout))
+	ctx := context.Background()
+
+	jobs := make(chan netip.Addr)
+	var (
+		mu    sync.Mutex
+		found []discoveredLight
+		wg    sync.WaitGroup
+	)
+	for w := 0; w < scanWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range jobs {
+				info, err := probe.Light(addr.String()).Info(ctx)
+				if err != nil {
+					continue
+				}
+				d := discoveredLight{IP: addr.String(), Port: keylight.DefaultPort, Info: info}
+				mu.Lock()
+				found = append(found, d)
+				if onFound != nil {
+					onFound(d)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, addr := range hosts {
+		jobs <- addr
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(found, func(i, j int) bool {
+		a, _ := netip.ParseAddr(found[i].IP)
+		b, _ := netip.ParseAddr(found[j].IP)
+		return a.Less(b)
+	})
+	return found, nil
+}
+
+// subnetHosts lists the host addresses in cidr, leaving out the network
+// and broadcast addresses of IPv4 subnets larger than /31
+func subnetHosts(cidr string) ([]netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: %w", cidr, err)
+	}
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 16 {
+		return nil, fmt.Errorf("subnet %s is too large to scan (max %d hosts)", prefix, scanMaxHosts)
+	}
+
+	var hosts []netip.Addr
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+	}
+	if prefix.Addr().Is4() && hostBits > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		cidr        string
+		count       int
+		first, last string
+	}{
+		{"192.168.1.0/24", 254, "192.168.1.1", "192.168.1.254"},
+		{"192.168.1.77/30", 2, "192.168.1.77", "192.168.1.78"}, // host bits are masked off
+		{"10.0.0.0/31", 2, "10.0.0.0", "10.0.0.1"},
+		{"10.0.0.5/32", 1, "10.0.0.5", "10.0.0.5"},
+		{"10.1.0.0/16", 65534, "10.1.0.1", "10.1.255.254"},
+		{"fd00::/126", 4, "fd00::", "fd00::3"}, // IPv6 has no broadcast address
+	}
+	for _, tt := range tests {
+		hosts, err := subnetHosts(tt.cidr)
+		if err != nil {
+			t.Errorf("subnetHosts(%q): %v", tt.cidr, err)
+			continue
+		}
+		if len(hosts) != tt.count {
+			t.Errorf("subnetHosts(%q) = %d hosts, want %d", tt.cidr, len(hosts), tt.count)
+			continue
+		}
+		first, last := netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last)
+		if hosts[0] != first || hosts[len(hosts)-1] != last {
+			t.Errorf("subnetHosts(%q) = %s..%s, want %s..%s", tt.cidr, hosts[0], hosts[len(hosts)-1], first, last)
+		}
+	}
+}
+
+func TestSubnetHostsErrors(t *testing.T) {
+	for _, cidr := range []string{"192.168.1.0", "192.168.1.0/33", "lan", "10.0.0.0/15", "fd00::/64"} {
+		if hosts, err := subnetHosts(cidr); err == nil {
+			t.Errorf("subnetHosts(%q) = %d hosts, want an error", cidr, len(hosts))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+
+	"elgato-keylight/keylight"
+)
+
+// sceneResult is the outcome of capturing or applying one light's state
+type sceneResult struct {
+	light *LightRecord
+	state keylight.State
+	err   error
+}
+
+// captureScene reads the current state of each light through its handle,
+// keyed by recordKey. Lights that cannot be read are reported in the results
+// and left out of the scene.
+func captureScene(ctx context.Context, config *Config, lights []*LightRecord, handles map[string]keylight.Light) (Scene, []sceneResult) {
+	results := fanOut(ctx, lights, func(ctx context.Context, l *LightRecord) sceneResult {
+		state, err := handles[recordKey(l)].State(ctx)
+		return sceneResult{light: l, state: state, err: err}
+	})
+
+	scene := make(Scene, len(lights))
+	for _, r := range results {
+		if r.err == nil {
+			scene[config.keyOf(r.light)] = r.state
+		}
+	}
+	return scene, results
+}
+
+// sceneChange is a light that a scene sets, the handle to reach it and the
+// state it is set to
+type sceneChange struct {
+	light  *LightRecord
+	handle keylight.Light
+	state  keylight.State
+}
+
+// sceneChanges returns what applying a scene changes, in index order, with
+// handles taken from handles by recordKey. Lights in the scene that are no
+// longer configured are skipped. The config is only read here, so the
+// changes can be applied in the background.
+func (c *Config) sceneChanges(scene Scene, handles map[string]keylight.Light) []sceneChange {
+	lights := c.sceneLights(scene)
+	changes := make([]sceneChange, len(lights))
+	for i, light := range lights {
+		changes[i] = sceneChange{light: light, handle: handles[recordKey(light)], state: scene[c.keyOf(light)]}
+	}
+	return changes
+}
+
+// applyScene sets every light to its saved state
+func applyScene(ctx context.Context, changes []sceneChange) []sceneResult {
+	return fanOut(ctx, changes, func(ctx context.Context, c sceneChange) sceneResult {
+		err := c.handle.Set(ctx, keylight.Patch{
+			On:          keylight.Bool(c.state.On),
+			Brightness:  keylight.Int(c.state.Brightness),
+			Temperature: keylight.Int(c.state.Temperature),
+		})
+		return sceneResult{light: c.light, state: c.state, err: err}
+	})
+}
+
+// sceneLights returns the configured lights in a scene, in index order
+func (c *Config) sceneLights(scene Scene) []*LightRecord {
+	var lights []*LightRecord
+	for _, light := range c.orderedLights() {
+		if _, ok := scene[c.keyOf(light)]; ok {
+			lights = append(lights, light)
+		}
+	}
+	return lights
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"elgato-keylight/keylight"
+)
+
+func TestCaptureScene(t *testing.T) {
+	desk, deskRecord := newFakeLight(t, "BW001", "Desk")
+	_, shelf := newFakeLight(t, "BW002", "Shelf")
+	gone := &LightRecord{Serial: "BW003", Name: "Gone", IP: "127.0.0.2"} // does not answer
+	config := testConfig(t, deskRecord, shelf, gone)
+	desk.state = keylight.State{On: true, Brightness: 30, Temperature: 5000}
+
+	scene, results := captureScene(context.Background(), config, config.orderedLights(), config.lightHandles(config.orderedLights(), nil))
+
+	// Results follow the lights' order; the light that did not answer is
+	// reported and left out
+	if len(results) != 3 || results[0].light != deskRecord || results[1].light != gone || results[2].light != shelf {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[1].err == nil || results[0].err != nil || results[2].err != nil {
+		t.Errorf("errors = %v, %v, %v", results[0].err, results[1].err, results[2].err)
+	}
+	if len(scene) != 2 || scene["BW001"] != desk.state || scene["BW002"] != results[2].state {
+		t.Errorf("scene = %+v", scene)
+	}
+}
+
+func TestApplyScene(t *testing.T) {
+	desk, deskRecord := newFakeLight(t, "BW001", "Desk")
+	shelf, shelfRecord := newFakeLight(t, "BW002", "Shelf")
+	config := testConfig(t, deskRecord, shelfRecord)
+	before := shelf.current()
+
+	// Only lights in the scene are set; ones no longer configured are skipped
+	scene := Scene{
+		"BW001": {On: false, Brightness: 20, Temperature: 3000},
+		"BW009": {On: true, Brightness: 100, Temperature: 7000},
+	}
+	results := applyScene(context.Background(), config.sceneChanges(scene, config.lightHandles(config.orderedLights(), nil)))
+
+	if len(results) != 1 || results[0].light != deskRecord || results[0].err != nil {
+		t.Fatalf("results = %+v", results)
+	}
+	got := desk.current()
+	if got.On || got.Brightness != 20 || got.Temperature != keylight.DeviceToKelvin(keylight.KelvinToDevice(3000)) {
+		t.Errorf("desk = %+v", got)
+	}
+	if shelf.current() != before || shelf.puts != 0 {
+		t.Errorf("shelf changed to %+v", shelf.current())
+	}
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"elgato-keylight/keylight"
+)
+
+// Server defaults, overridden by serve --listen, --poll and --discover
+const (
+	defaultListenAddr        = "127.0.0.1:8080"
+	defaultPollInterval      = 5 * time.Second
+	defaultDiscoveryInterval = time.Minute
+)
+
+// readHeaderTimeout bounds how long a client may take to send its request
+// headers, so slow clients cannot hold connections open
+const readHeaderTimeout = 10 * time.Second
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// server serves the REST API. It keeps the last known state of every
+// configured light, refreshed by a background poll and by the changes it
+// makes, so reads do not have to wait for the lights.
+type server struct {
+	addr   string // the TCP address listened on, if any
+	events *eventHub
+
+	mu      sync.Mutex
+	config  *Config
+	handles map[string]keylight.Light // one per light, by light key
+	loaded  time.Time                 // modification time of the config file when it was read
+	cache   map[*LightRecord]cachedState
+}
+
+// cachedState is the last known state of a light
+type cachedState struct {
+	state   keylight.State
+	err     error     // the last read failed
+	updated time.Time // when state was read or set
+}
+
+// apiLight is a light as served by the REST API
+type apiLight struct {
+	Index   int             `json:"index"` // position in list
+	Name    string          `json:"name"`
+	Serial  string          `json:"serial,omitempty"`
+	Address string          `json:"address"`
+	Stale   bool            `json:"stale,omitempty"`
+	OK      bool            `json:"ok"` // the last request to the light succeeded
+	Error   string          `json:"error,omitempty"`
+	State   *keylight.State `json:"state,omitempty"`
+	Updated *time.Time      `json:"updated,omitempty"` // when State was read or set
+}
+
+// apiPatch is the body of PATCH /lights/{id}; fields left out are unchanged
+type apiPatch struct {
+	On          *bool `json:"on"`
+	Brightness  *int  `json:"brightness"`
+	Temperature *int  `json:"temperature"` // Kelvin
+}
+
+func newServer(config *Config) *server {
+	config.relocate = relocateSaved
+	s := &server{
+		events:  newEventHub(),
+		config:  config,
+		handles: config.lightHandles(config.orderedLights(), printMoved),
+		cache:   make(map[*LightRecord]cachedState),
+	}
+	if info, err := os.Stat(getConfigPath()); err == nil {
+		s.loaded = info.ModTime()
+	}
+	return s
+}
+
+// relocateSaved records a light found at a new address in the config file
+// as it is now, instead of saving the server's copy over edits made with
+// the CLI since it was read. The server's records are never written while
+// requests read them; its handles keep the new address.
+func relocateSaved(light *LightRecord, ip string, port int) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config := loadConfig()
+	saved, ok := config.Lights[recordKey(light)]
+	if !ok {
+		return
+	}
+	saved.IP = ip
+	saved.Stale = false
+	if port != 0 {
+		saved.Port = port
+	}
+	saveConfig(config)
+}
+
+// currentConfig returns the config, reading it again if the file changed,
+// so lights, groups and scenes changed with the CLI are served without a
+// restart. The lights get new handles, for their addresses as saved;
+// cached states carry over.
+func (s *server) currentConfig() *Config {
+	info, err := os.Stat(getConfigPath())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || !info.ModTime().After(s.loaded) {
+		return s.config
+	}
+
+	config := loadConfig()
+	config.relocate = relocateSaved
+	cache := make(map[*LightRecord]cachedState, len(config.Lights))
+	for key, light := range config.Lights {
+		if old, ok := s.config.Lights[key]; ok {
+			if c, ok := s.cache[old]; ok {
+				cache[light] = c
+			}
+		}
+	}
+	s.config, s.loaded, s.cache = config, info.ModTime(), cache
+	s.handles = config.lightHandles(config.orderedLights(), printMoved)
+	return config
+}
+
+// handle returns the server's handle for a light. A light dropped by a
+// reload since its config was read gets one of its own.
+func (s *server) handle(light *LightRecord) keylight.Light {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.handles[recordKey(light)]; ok {
+		return h
+	}
+	return s.config.lightHandle(light, printMoved)
+}
+
+// handlesFor maps each light's key to the server's handle for it
+func (s *server) handlesFor(lights []*LightRecord) map[string]keylight.Light {
+	handles := make(map[string]keylight.Light, len(lights))
+	for _, light := range lights {
+		handles[recordKey(light)] = s.handle(light)
+	}
+	return handles
+}
+
+// handler routes the REST API
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /lights", s.handleLights)
+	mux.HandleFunc("GET /lights/{id}", s.handleLight)
+	mux.HandleFunc("PATCH /lights/{id}", s.sameOrigin(s.handlePatchLight))
+	mux.HandleFunc("POST /lights/{id}/toggle", s.sameOrigin(s.handleToggleLight))
+	mux.HandleFunc("POST /groups/{name}/toggle", s.sameOrigin(s.handleToggleGroup))
+	mux.HandleFunc("POST /scenes/{name}/apply", s.sameOrigin(s.handleApplyScene))
+	mux.HandleFunc("GET /events", s.handleEvents)
+	mux.Handle("GET /metrics", s.metricsHandler())
+	mux.HandleFunc("GET /openapi.yaml", s.handleOpenAPI)
+	mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	return mux
+}
+
+// sameOrigin guards a route that changes lights against requests from web
+// pages on other sites. Browsers send some cross-origin POSTs without
+// asking first; they cannot set a JSON Content-Type on those, so requiring
+// one makes the browser ask (a CORS preflight, which the server does not
+// answer). Requests the browser marks as coming from another origin are
+// refused outright, as are requests for a host name the server was not
+// asked to listen on, which a site can point at the server's address to
+// look like its own origin.
+func (s *server) sameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+			writeError(w, http.StatusForbidden, "cross-origin request refused")
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := neturl.Parse(origin); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, "cross-origin request refused")
+				return
+			}
+		}
+		if !s.servesHost(r.Host) {
+			writeError(w, http.StatusForbidden, "unknown host %q refused", r.Host)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// servesHost tells whether a request's Host names the server: localhost, a
+// loopback address or the host it listens on. When it listens on every
+// interface any IP address will do, but no other name. Without a TCP
+// address to check against, every host is accepted.
+func (s *server) servesHost(hostport string) bool {
+	if s.addr == "" {
+		return true
+	}
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	listen, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return false
+	}
+	listenIP := net.ParseIP(listen)
+	switch {
+	case listen == "" || listenIP != nil && listenIP.IsUnspecified():
+		return ip != nil
+	case listenIP != nil:
+		return listenIP.Equal(ip)
+	}
+	return strings.EqualFold(host, listen)
+}
+
+// refresh reads the state of lights into the cache
+func (s *server) refresh(ctx context.Context, lights []*LightRecord) {
+	fanOut(ctx, lights, func(ctx context.Context, light *LightRecord) struct{} {
+		state, err := s.handle(light).State(ctx)
+		s.store(light, state, err)
+		return struct{}{}
+	})
+}
+
+// poll refreshes every light's state until ctx is done
+func (s *server) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.refresh(ctx, s.currentConfig().orderedLights())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// store records a light's state, or the error reading it, and sends the
+// events for what changed. A failed read keeps the last known state.
+func (s *server) store(light *LightRecord, state keylight.State, err error) {
+	s.mu.Lock()
+	prev, known := s.cache[light]
+	c := prev
+	c.err = err
+	if err == nil {
+		c.state, c.updated = state, time.Now()
+	}
+	s.cache[light] = c
+	config := s.config
+	s.mu.Unlock()
+
+	types := stateEvents(prev, c, known)
+	if len(types) == 0 {
+		return
+	}
+	v := s.view(config, light)
+	for _, t := range types {
+		e := event{Type: t, Time: time.Now(), Light: &v}
+		if t == eventLightChanged {
+			e.Previous = &prev.state
+		}
+		s.events.publish(e)
+	}
+}
+
+// cached returns a light's last known state
+func (s *server) cached(light *LightRecord) (cachedState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cache[light]
+	return c, ok && !c.updated.IsZero()
+}
+
+// applied merges a patch that was sent to a light into its cached state,
+// reading the state instead if none is known
+func (s *server) applied(ctx context.Context, light *LightRecord, handle keylight.Light, p keylight.Patch) {
+	c, ok := s.cached(light)
+	if !ok {
+		state, err := handle.State(ctx)
+		s.store(light, state, err)
+		return
+	}
+	if p.On != nil {
+		c.state.On = *p.On
+	}
+	if p.Brightness != nil {
+		c.state.Brightness = *p.Brightness
+	}
+	if p.Temperature != nil {
+		c.state.Temperature = *p.Temperature
+	}
+	s.store(light, c.state, nil)
+}
+
+// view describes a light with its cached state
+func (s *server) view(config *Config, light *LightRecord) apiLight {
+	v := apiLight{Name: light.Name, Serial: light.Serial, Address: light.Addr(), Stale: light.Stale, OK: true}
+	for i, l := range config.orderedLights() {
+		if l == light {
+			v.Index = i + 1
+		}
+	}
+
+	s.mu.Lock()
+	c, ok := s.cache[light]
+	s.mu.Unlock()
+	if !ok {
+		return v
+	}
+	if c.err != nil {
+		v.OK, v.Error = false, c.err.Error()
+	}
+	if !c.updated.IsZero() {
+		v.State, v.Updated = &c.state, &c.updated
+	}
+	return v
+}
+
+// views describes lights, with the error of the request just made for each
+// (nil meaning it succeeded) taking the place of the cached one
+func (s *server) views(config *Config, lights []*LightRecord, errs []error) []apiLight {
+	views := make([]apiLight, len(lights))
+	for i, light := range lights {
+		views[i] = s.view(config, light)
+		views[i].OK, views[i].Error = errs[i] == nil, ""
+		if errs[i] != nil {
+			views[i].Error = errs[i].Error()
+		}
+	}
+	return views
+}
+
+func (s *server) handleLights(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	lights := config.orderedLights()
+	if r.URL.Query().Has("refresh") {
+		s.refresh(r.Context(), lights)
+	}
+	views := make([]apiLight, len(lights))
+	for i, light := range lights {
+		views[i] = s.view(config, light)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"lights": views})
+}
+
+func (s *server) handleLight(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	light, ok := s.findLight(w, config, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	// A light that did not answer the last poll may be back already
+	if c, ok := s.cached(light); !ok || c.err != nil || r.URL.Query().Has("refresh") {
+		s.refresh(r.Context(), []*LightRecord{light})
+	}
+	writeJSON(w, http.StatusOK, s.view(config, light))
+}
+
+func (s *server) handlePatchLight(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	light, ok := s.findLight(w, config, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	var body apiPatch
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: %v", err)
+		return
+	}
+	switch {
+	case body.On == nil && body.Brightness == nil && body.Temperature == nil:
+		writeError(w, http.StatusBadRequest, "nothing to change: set on, brightness or temperature")
+		return
+	case body.Brightness != nil && (*body.Brightness < keylight.MinBrightness || *body.Brightness > keylight.MaxBrightness):
+		writeError(w, http.StatusBadRequest, "brightness must be between %d and %d", keylight.MinBrightness, keylight.MaxBrightness)
+		return
+	case body.Temperature != nil && (*body.Temperature < keylight.MinTemperature || *body.Temperature > keylight.MaxTemperature):
+		writeError(w, http.StatusBadRequest, "temperature must be between %d and %d", keylight.MinTemperature, keylight.MaxTemperature)
+		return
+	}
+
+	patch := keylight.Patch{On: body.On, Brightness: body.Brightness, Temperature: body.Temperature}
+	handle := s.handle(light)
+	if err := handle.Set(r.Context(), patch); err != nil {
+		writeError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+	s.applied(r.Context(), light, handle, patch)
+	writeJSON(w, http.StatusOK, s.view(config, light))
+}
+
+func (s *server) handleToggleLight(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	light, ok := s.findLight(w, config, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	if err := s.toggle(r.Context(), light); err != nil {
+		writeError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.view(config, light))
+}
+
+// toggle flips a light's power. With its state cached that is a single
+// request to the light.
+func (s *server) toggle(ctx context.Context, light *LightRecord) error {
+	handle := s.handle(light)
+	var patch keylight.Patch
+	if c, ok := s.cached(light); ok && c.err == nil {
+		patch.On = keylight.Bool(!c.state.On)
+		if err := handle.Set(ctx, patch); err != nil {
+			return err
+		}
+	} else {
+		on, err := handle.Toggle(ctx)
+		if err != nil {
+			return err
+		}
+		patch.On = &on
+	}
+	s.applied(ctx, light, handle, patch)
+	return nil
+}
+
+func (s *server) handleToggleGroup(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	name := r.PathValue("name")
+	lights, ok := config.groupLights(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "group %q not found", name)
+		return
+	}
+
+	// Keep the group in sync: if any light is on, turn them all off
+	var unknown []*LightRecord
+	for _, light := range lights {
+		if _, ok := s.cached(light); !ok {
+			unknown = append(unknown, light)
+		}
+	}
+	s.refresh(r.Context(), unknown)
+	anyOn := false
+	for _, light := range lights {
+		if c, ok := s.cached(light); ok && c.state.On {
+			anyOn = true
+		}
+	}
+
+	patch := keylight.Patch{On: keylight.Bool(!anyOn)}
+	errs := fanOut(r.Context(), lights, func(ctx context.Context, light *LightRecord) error {
+		handle := s.handle(light)
+		err := handle.Set(ctx, patch)
+		if err == nil {
+			s.applied(ctx, light, handle, patch)
+		}
+		return err
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"lights": s.views(config, lights, errs)})
+}
+
+func (s *server) handleApplyScene(w http.ResponseWriter, r *http.Request) {
+	config := s.currentConfig()
+	name := r.PathValue("name")
+	scene, ok := config.Scenes[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "scene %q not found", name)
+		return
+	}
+
+	results := applyScene(r.Context(), config.sceneChanges(scene, s.handlesFor(config.sceneLights(scene))))
+	lights := make([]*LightRecord, len(results))
+	errs := make([]error, len(results))
+	for i, res := range results {
+		lights[i], errs[i] = res.light, res.err
+		if res.err == nil {
+			s.store(res.light, res.state, nil)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"lights": s.views(config, lights, errs)})
+}
+
+// findLight looks up the light a request names, answering it with an error
+// if there is no such light or the name is shared
+func (s *server) findLight(w http.ResponseWriter, config *Config, id string) (*LightRecord, bool) {
+	light, err := config.findLight(id)
+	switch {
+	case errors.Is(err, errAmbiguousName):
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return nil, false
+	case err != nil:
+		writeError(w, http.StatusNotFound, "light %q not found", id)
+		return nil, false
+	}
+	return light, true
+}
+
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, ".yaml") {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+		return
+	}
+	var spec any
+	if err := yaml.Unmarshal(openAPISpec, &spec); err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, spec)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func cliServe(config *Config, args []string) {
+	const usage = "Usage: keylight serve [--listen 127.0.0.1:8080] [--poll 5s] [--discover 1m|0]"
+
+	opts, rest, err := cutOptions(args, []string{"--listen", "--poll", "--discover"}, nil)
+	if err != nil {
+		out.fail(exitUsage, "%v\n%s", err, usage)
+	}
+	if len(rest) > 0 {
+		out.fail(exitUsage, "Unknown option: %s\n%s", rest[0], usage)
+	}
+	listen := defaultListenAddr
+	interval := defaultPollInterval
+	discoverEvery := defaultDiscoveryInterval
+	for _, opt := range opts {
+		switch opt.name {
+		case "--listen":
+			listen = opt.value
+		case "--poll":
+			d, err := time.ParseDuration(opt.value)
+			if err != nil || d <= 0 {
+				out.fail(exitUsage, "Invalid poll interval: %s", opt.value)
+			}
+			interval = d
+		case "--discover":
+			d, err := time.ParseDuration(opt.value)
+			if err != nil || d < 0 {
+				out.fail(exitUsage, "Invalid discovery interval: %s", opt.value)
+			}
+			discoverEvery = d
+		}
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		out.fail(exitFailed, "✗ %v", err)
+	}
+	out.say("Serving %d light(s) on http://%s (API description at /openapi.yaml)", len(config.Lights), l.Addr())
+	if err := newServer(config).run(l, interval, discoverEvery); err != nil {
+		out.fail(exitFailed, "✗ %v", err)
+	}
+}
+
+// run serves the API on l, polls the lights every interval and browses for
+// new ones every discoverEvery (if not zero) until the process is
+// interrupted or terminated
+func (s *server) run(l net.Listener, interval, discoverEvery time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Over TCP the Host of requests that change lights is checked
+	if l.Addr().Network() == "tcp" {
+		s.addr = l.Addr().String()
+	}
+	// Requests end with the server, so event streams do not hold it open
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go s.poll(ctx, interval)
+	if discoverEvery > 0 {
+		go s.discover(ctx, discoverEvery)
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Changes are refused to web pages on other sites
+func TestSameOrigin(t *testing.T) {
+	_, record := newFakeLight(t, "BW001", "Desk")
+	config := testConfig(t, record)
+	config.Groups = map[string][]string{"desk": {"BW001"}}
+	s := newServer(config)
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+	s.addr = srv.Listener.Addr().String()
+	host := s.addr
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		want    int
+	}{
+		{"JSON toggle", "POST", "/groups/desk/toggle", "", map[string]string{"Content-Type": "application/json"}, http.StatusOK},
+		{"JSON patch", "PATCH", "/lights/1", `{"brightness": 40}`, map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusOK},
+		{"same origin", "POST", "/groups/desk/toggle", "", map[string]string{"Content-Type": "application/json", "Origin": "http://" + host, "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"form post", "POST", "/groups/desk/toggle", "a=b", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"no content type", "POST", "/scenes/video/apply", "", nil, http.StatusUnsupportedMediaType},
+		{"plain text patch", "PATCH", "/lights/1", `{"brightness": 40}`, map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"foreign origin", "POST", "/groups/desk/toggle", "", map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"cross site", "POST", "/groups/desk/toggle", "", map[string]string{"Content-Type": "application/json", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"rebound host", "PATCH", "/lights/1", `{"brightness": 40}`, map[string]string{"Content-Type": "application/json", "Host": "evil.example"}, http.StatusForbidden},
+		{"rebound host and origin", "PATCH", "/lights/1", `{"brightness": 40}`, map[string]string{"Content-Type": "application/json", "Host": "evil.example:80", "Origin": "http://evil.example:80"}, http.StatusForbidden},
+		{"localhost", "PATCH", "/lights/1", `{"brightness": 40}`, map[string]string{"Content-Type": "application/json", "Host": "localhost:8080"}, http.StatusOK},
+		{"reads are open", "GET", "/lights", "", map[string]string{"Origin": "https://evil.example"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if h := tt.headers["Host"]; h != "" {
+			req.Host = h
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestServesHost(t *testing.T) {
+	tests := []struct {
+		addr, host string
+		want       bool
+	}{
+		{"", "evil.example", true},
+		{"127.0.0.1:8080", "localhost:8080", true},
+		{"127.0.0.1:8080", "LOCALHOST", true},
+		{"127.0.0.1:8080", "127.0.0.1:8080", true},
+		{"127.0.0.1:8080", "127.0.0.2:8080", true},
+		{"127.0.0.1:8080", "[::1]:8080", true},
+		{"127.0.0.1:8080", "evil.example:8080", false},
+		{"127.0.0.1:8080", "192.168.1.5:8080", false},
+		{"192.168.1.5:8080", "192.168.1.5:8080", true},
+		{"192.168.1.5:8080", "192.168.1.6:8080", false},
+		{"[::]:8080", "192.168.1.5:8080", true},
+		{"[::]:8080", "[fe80::1]:8080", true},
+		{"0.0.0.0:8080", "10.0.0.2", true},
+		{"0.0.0.0:8080", "keylight.lan:8080", false},
+		{":8080", "evil.example:8080", false},
+		{"keylight.lan:8080", "keylight.lan:8080", true},
+		{"keylight.lan:8080", "evil.example:8080", false},
+	}
+	for _, tt := range tests {
+		s := &server{addr: tt.addr}
+		if got := s.servesHost(tt.host); got != tt.want {
+			t.Errorf("listening on %q: servesHost(%q) = %v, want %v", tt.addr, tt.host, got, tt.want)
+		}
+	}
+}
+
+// Requests for a light reach it through the server's one handle for it,
+// and a name two lights share is refused rather than picking one
+func TestServerLights(t *testing.T) {
+	left, leftRecord := newFakeLight(t, "BW001", "Elgato Key Light")
+	right, rightRecord := newFakeLight(t, "BW002", "Elgato Key Light")
+	config := testConfig(t, leftRecord, rightRecord)
+	config.Order = []string{"BW001", "BW002"}
+	s := newServer(config)
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	patch := func(id, body string) (int, apiLight) {
+		req, _ := http.NewRequest("PATCH", srv.URL+"/lights/"+id, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var v apiLight
+		json.NewDecoder(resp.Body).Decode(&v)
+		return resp.StatusCode, v
+	}
+
+	if status, v := patch("BW002", `{"brightness": 30}`); status != http.StatusOK || v.Index != 2 || v.State == nil || v.State.Brightness != 30 {
+		t.Errorf("PATCH BW002: status %d, %+v", status, v)
+	}
+	if left.current().Brightness != 50 || right.current().Brightness != 30 {
+		t.Errorf("brightness = %d, %d; want 50, 30", left.current().Brightness, right.current().Brightness)
+	}
+	if status, _ := patch("Elgato Key Light", `{"on": false}`); status != http.StatusBadRequest {
+		t.Errorf("PATCH by a shared name: status %d, want %d", status, http.StatusBadRequest)
+	}
+	if status, _ := patch("Nowhere", `{"on": false}`); status != http.StatusNotFound {
+		t.Errorf("PATCH an unknown light: status %d, want %d", status, http.StatusNotFound)
+	}
+	if !left.current().On || !right.current().On {
+		t.Error("a refused request changed a light")
+	}
+	if s.handle(rightRecord) != s.handles["BW002"] || len(s.handles) != 2 {
+		t.Errorf("handles = %v", s.handles)
+	}
+}
+
+// The server saves a move into the config file as it is now, keeping edits
+// made since it read it
+func TestRelocateSaved(t *testing.T) {
+	record := &LightRecord{Serial: "BW001", Name: "Desk", IP: "10.0.0.5", Stale: true}
+	config := testConfig(t, record)
+	saveConfig(config)
+
+	// Edited with the CLI after the server read the config
+	edited := loadConfig()
+	edited.Lights["BW001"].Name = "Key Left"
+	saveConfig(edited)
+
+	relocateSaved(record, "10.0.0.9", 9124)
+	saved := loadConfig().Lights["BW001"]
+	if saved.Name != "Key Left" || saved.IP != "10.0.0.9" || saved.Port != 9124 || saved.Stale {
+		t.Errorf("saved %+v", saved)
+	}
+	if record.IP != "10.0.0.5" {
+		t.Errorf("server record changed to %s", record.IP)
+	}
+}